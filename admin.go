@@ -0,0 +1,343 @@
+package crawler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gocrawler/base"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the number of recent errors kept for GET /errors
+var adminRecentErrors = 100
+
+type AdminArgs struct {
+	//the address to listen on, e.g. "127.0.0.1:8080". It's required by ListenAndServe,
+	//so the server never listens on all the interfaces by default
+	Addr string
+	//the bearer token required by every endpoint, empty means no authentication
+	Token string
+}
+
+type adminError struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	Message     string    `json:"message"`
+	Url         string    `json:"url,omitempty"`
+	Depth       uint32    `json:"depth"`
+	ComponentId string    `json:"component_id,omitempty"`
+	Attempt     uint32    `json:"attempt,omitempty"`
+	Retryable   bool      `json:"retryable"`
+}
+
+// AdminServer is an embedded http server to inspect and control a running crawl:
+//
+//	GET  /summary              the summary of the scheduler as json
+//	GET  /errors               the recent errors as json
+//	GET  /errors/stream        the errors as server-sent events
+//	POST /stop?drain=30s       stop the crawl, drain the stages first if drain is given
+//	POST /pause                pause the crawl
+//	POST /resume               resume the crawl
+//	POST /seeds                add seeds, the body is {"urls": ["http://..."]}
+//	POST /politeness           change the limits, the body is {"min_delay": "1s", "max_concurrency": 2},
+//	                           an omitted limit is kept
+type AdminServer struct {
+	sched       Scheduler
+	args        AdminArgs
+	server      *http.Server
+	errors      []adminError
+	subscribers map[chan adminError]bool
+	mutex       sync.Mutex
+}
+
+func NewAdminServer(sched Scheduler, args AdminArgs) *AdminServer {
+	if sched == nil {
+		panic(errors.New("The scheduler is nil!"))
+	}
+	admin := &AdminServer{
+		sched:       sched,
+		args:        args,
+		subscribers: make(map[chan adminError]bool),
+	}
+	admin.server = &http.Server{Addr: args.Addr, Handler: admin.Handler()}
+	return admin
+}
+
+// ConsumeErrors takes over the error channel of the started scheduler, the errors are kept
+// for the error endpoints and passed to onError if it's not nil. It returns once the
+// error channel is closed
+func (a *AdminServer) ConsumeErrors(onError func(err error)) {
+	errorChan := a.sched.ErrorChan()
+	if errorChan == nil {
+		return
+	}
+	for err := range errorChan {
+		a.publish(err)
+		if onError != nil {
+			onError(err)
+		}
+	}
+}
+
+func (a *AdminServer) publish(err error) {
+	entry := adminError{Time: time.Now(), Message: err.Error()}
+	if cError, ok := err.(base.CrawlerError); ok {
+		entry.Time = cError.Time()
+		entry.Type = string(cError.Type())
+		entry.Url = cError.Url()
+		entry.Depth = cError.Depth()
+		entry.ComponentId = cError.ComponentId()
+		entry.Attempt = cError.Attempt()
+		entry.Retryable = cError.Retryable()
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.errors = append(a.errors, entry)
+	if len(a.errors) > adminRecentErrors {
+		a.errors = a.errors[len(a.errors)-adminRecentErrors:]
+	}
+	//a slow subscriber misses errors instead of blocking the crawl
+	for subscriber := range a.subscribers {
+		select {
+		case subscriber <- entry:
+		default:
+		}
+	}
+}
+
+// ListenAndServe blocks until the server is shut down
+func (a *AdminServer) ListenAndServe() error {
+	if a.args.Addr == "" {
+		return errors.New("The admin address is empty!")
+	}
+	err := a.server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Serve is like ListenAndServe but uses the listener, e.g. to listen on a random port
+func (a *AdminServer) Serve(listener net.Listener) error {
+	err := a.server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (a *AdminServer) Shutdown(ctx context.Context) error {
+	return a.server.Shutdown(ctx)
+}
+
+func (a *AdminServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/summary", a.get(a.handleSummary))
+	mux.HandleFunc("/errors", a.get(a.handleErrors))
+	mux.HandleFunc("/errors/stream", a.get(a.handleErrorStream))
+	mux.HandleFunc("/stop", a.post(a.handleStop))
+	mux.HandleFunc("/pause", a.post(a.handlePause))
+	mux.HandleFunc("/resume", a.post(a.handleResume))
+	mux.HandleFunc("/seeds", a.post(a.handleSeeds))
+	mux.HandleFunc("/politeness", a.post(a.handlePoliteness))
+	return a.authenticate(mux)
+}
+
+func (a *AdminServer) authenticate(next http.Handler) http.Handler {
+	if a.args.Token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//the comparison takes the same time wherever the token differs
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, []byte("Bearer "+a.args.Token)) != 1 {
+			writeJsonError(w, http.StatusUnauthorized, errors.New("The token is invalid!"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *AdminServer) get(handle http.HandlerFunc) http.HandlerFunc {
+	return allowMethod(http.MethodGet, handle)
+}
+
+func (a *AdminServer) post(handle http.HandlerFunc) http.HandlerFunc {
+	return allowMethod(http.MethodPost, handle)
+}
+
+func allowMethod(method string, handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJsonError(w, http.StatusMethodNotAllowed, errors.New("The method is not allowed!"))
+			return
+		}
+		handle(w, r)
+	}
+}
+
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeJsonError(w http.ResponseWriter, status int, err error) {
+	writeJson(w, status, map[string]string{"error": err.Error()})
+}
+
+func (a *AdminServer) handleSummary(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, a.sched.Summary(""))
+}
+
+func (a *AdminServer) handleErrors(w http.ResponseWriter, r *http.Request) {
+	a.mutex.Lock()
+	recent := append([]adminError{}, a.errors...)
+	a.mutex.Unlock()
+	writeJson(w, http.StatusOK, recent)
+}
+
+func (a *AdminServer) handleErrorStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJsonError(w, http.StatusInternalServerError, errors.New("Streaming is not supported!"))
+		return
+	}
+	subscriber := make(chan adminError, 16)
+	a.mutex.Lock()
+	a.subscribers[subscriber] = true
+	a.mutex.Unlock()
+	defer func() {
+		a.mutex.Lock()
+		delete(a.subscribers, subscriber)
+		a.mutex.Unlock()
+	}()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case entry := <-subscriber:
+			data, _ := json.Marshal(entry)
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (a *AdminServer) handleStop(w http.ResponseWriter, r *http.Request) {
+	drain := r.URL.Query().Get("drain")
+	if drain == "" {
+		if !a.sched.Stop() {
+			writeJsonError(w, http.StatusConflict, errors.New("The scheduler is not running!"))
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]bool{"stopped": true})
+		return
+	}
+	timeout, err := time.ParseDuration(drain)
+	if err != nil || timeout < 0 {
+		writeJsonError(w, http.StatusBadRequest, errors.New("Invalid drain timeout: "+drain))
+		return
+	}
+	report, ok := a.sched.StopDrain(timeout)
+	if !ok {
+		writeJsonError(w, http.StatusConflict, errors.New("The scheduler is not running!"))
+		return
+	}
+	writeJson(w, http.StatusOK, report)
+}
+
+func (a *AdminServer) handlePause(w http.ResponseWriter, r *http.Request) {
+	if !a.sched.Pause() {
+		writeJsonError(w, http.StatusConflict, errors.New("The scheduler is not running!"))
+		return
+	}
+	writeJson(w, http.StatusOK, map[string]bool{"paused": true})
+}
+
+func (a *AdminServer) handleResume(w http.ResponseWriter, r *http.Request) {
+	if !a.sched.Resume() {
+		writeJsonError(w, http.StatusConflict, errors.New("The scheduler is not paused!"))
+		return
+	}
+	writeJson(w, http.StatusOK, map[string]bool{"paused": false})
+}
+
+type adminSeedResult struct {
+	Url   string `json:"url"`
+	Added bool   `json:"added"`
+	Error string `json:"error,omitempty"`
+}
+
+func (a *AdminServer) handleSeeds(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Urls []string `json:"urls"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(body.Urls) == 0 {
+		writeJsonError(w, http.StatusBadRequest, errors.New("The url list is empty!"))
+		return
+	}
+	results := make([]adminSeedResult, 0, len(body.Urls))
+	for _, rawUrl := range body.Urls {
+		result := adminSeedResult{Url: rawUrl}
+		httpReq, err := http.NewRequest(http.MethodGet, strings.TrimSpace(rawUrl), nil)
+		if err == nil {
+			err = a.sched.AddRequest(*base.NewRequest(httpReq, 0))
+		}
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Added = true
+		}
+		results = append(results, result)
+	}
+	writeJson(w, http.StatusOK, results)
+}
+
+func (a *AdminServer) handlePoliteness(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		MinDelay       *string `json:"min_delay"`
+		MaxConcurrency *uint32 `json:"max_concurrency"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+	minDelay, maxConcurrency, err := a.sched.PolitenessLimits()
+	if err != nil {
+		writeJsonError(w, http.StatusConflict, err)
+		return
+	}
+	if body.MinDelay != nil {
+		if minDelay, err = time.ParseDuration(*body.MinDelay); err != nil {
+			writeJsonError(w, http.StatusBadRequest, errors.New("Invalid min delay: "+*body.MinDelay))
+			return
+		}
+	}
+	if body.MaxConcurrency != nil {
+		maxConcurrency = *body.MaxConcurrency
+	}
+	if err := a.sched.SetPolitenessLimits(minDelay, maxConcurrency); err != nil {
+		writeJsonError(w, http.StatusConflict, err)
+		return
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"min_delay":       minDelay.String(),
+		"max_concurrency": maxConcurrency,
+	})
+}
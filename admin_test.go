@@ -0,0 +1,180 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"gocrawler/base"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// doAdminRequest sends the request to the admin server and decodes the json response into result
+func doAdminRequest(t *testing.T, server *httptest.Server, method string, path string, token string,
+	body string, result interface{}) int {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	content, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != nil {
+		if err := json.Unmarshal(content, result); err != nil {
+			t.Fatalf("%s %s: invalid json %q: %s", method, path, content, err)
+		}
+	}
+	return res.StatusCode
+}
+
+func TestAdminAuthentication(t *testing.T) {
+	sched := NewScheduler()
+	server := httptest.NewServer(NewAdminServer(sched, AdminArgs{Token: "secret"}).Handler())
+	defer server.Close()
+	tests := []struct {
+		token  string
+		method string
+		path   string
+		status int
+	}{
+		{"", http.MethodGet, "/summary", http.StatusUnauthorized},
+		{"wrong", http.MethodGet, "/summary", http.StatusUnauthorized},
+		{"secret", http.MethodGet, "/summary", http.StatusOK},
+		{"", http.MethodPost, "/stop", http.StatusUnauthorized},
+		{"secret", http.MethodGet, "/stop", http.StatusMethodNotAllowed},
+		{"secret", http.MethodPost, "/stop", http.StatusConflict},
+	}
+	for _, test := range tests {
+		status := doAdminRequest(t, server, test.method, test.path, test.token, "", nil)
+		if status != test.status {
+			t.Errorf("%s %s with token %q: status = %d, want %d", test.method, test.path, test.token,
+				status, test.status)
+		}
+	}
+}
+
+func TestAdminControl(t *testing.T) {
+	site := newTestSiteServer(nil)
+	defer site.Close()
+	sched := NewScheduler()
+	startTestScheduler(t, sched, context.Background(), site, 0)
+	admin := NewAdminServer(sched, AdminArgs{})
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		admin.ConsumeErrors(nil)
+	}()
+	server := httptest.NewServer(admin.Handler())
+	defer server.Close()
+
+	var summary SchedSummary
+	if status := doAdminRequest(t, server, http.MethodGet, "/summary", "", "", &summary); status != http.StatusOK ||
+		summary.Status != "running" {
+		t.Errorf("GET /summary = %d %q, want 200 running", status, summary.Status)
+	}
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/pause", "", http.StatusOK},
+		{http.MethodPost, "/pause", "", http.StatusConflict},
+		{http.MethodPost, "/resume", "", http.StatusOK},
+		{http.MethodPost, "/resume", "", http.StatusConflict},
+		{http.MethodPost, "/seeds", `{"urls": []}`, http.StatusBadRequest},
+		{http.MethodPost, "/seeds", `{"urls"`, http.StatusBadRequest},
+		{http.MethodPost, "/politeness", `{"min_delay": "1s"}`, http.StatusConflict},
+		{http.MethodPost, "/stop?drain=soon", "", http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		if status := doAdminRequest(t, server, step.method, step.path, "", step.body, nil); status != step.status {
+			t.Errorf("%s %s: status = %d, want %d", step.method, step.path, status, step.status)
+		}
+	}
+
+	//the seed on a closed port fails to download, the error is kept for GET /errors
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	var results []adminSeedResult
+	body := `{"urls": ["` + closed.URL + `/gone", "://bad"]}`
+	if status := doAdminRequest(t, server, http.MethodPost, "/seeds", "", body, &results); status != http.StatusOK {
+		t.Fatalf("POST /seeds: status = %d, want 200", status)
+	}
+	if len(results) != 2 || !results[0].Added || results[1].Added || results[1].Error == "" {
+		t.Errorf("POST /seeds = %+v, want the first added and the second refused", results)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var recent []adminError
+		doAdminRequest(t, server, http.MethodGet, "/errors", "", "", &recent)
+		if len(recent) > 0 {
+			if !strings.Contains(recent[0].Url, "/gone") || recent[0].Type != string(base.DOWNLOADER_ERROR) {
+				t.Errorf("GET /errors = %+v, want the download error of /gone", recent)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the download error is not in GET /errors")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var report DrainReport
+	if status := doAdminRequest(t, server, http.MethodPost, "/stop?drain=5s", "", "", &report); status != http.StatusOK ||
+		!report.Drained {
+		t.Errorf("POST /stop?drain=5s = %d %+v, want 200 drained", status, report)
+	}
+	if status := doAdminRequest(t, server, http.MethodPost, "/stop", "", "", nil); status != http.StatusConflict {
+		t.Errorf("POST /stop of a stopped scheduler: status = %d, want 409", status)
+	}
+	select {
+	case <-consumed:
+	case <-time.After(5 * time.Second):
+		t.Error("ConsumeErrors doesn't return once the scheduler is stopped")
+	}
+}
+
+func TestAdminPoliteness(t *testing.T) {
+	site := newTestSiteServer(nil)
+	defer site.Close()
+	sched := NewScheduler(WithPoliteness(PolitenessArgs{MinDelay: time.Millisecond, MaxConcurrency: 4}))
+	startTestScheduler(t, sched, context.Background(), site, 0)
+	defer sched.Stop()
+	server := httptest.NewServer(NewAdminServer(sched, AdminArgs{}).Handler())
+	defer server.Close()
+
+	tests := []struct {
+		body           string
+		status         int
+		minDelay       time.Duration
+		maxConcurrency uint32
+	}{
+		{`{"min_delay": "20ms"}`, http.StatusOK, 20 * time.Millisecond, 4},
+		{`{"max_concurrency": 2}`, http.StatusOK, 20 * time.Millisecond, 2},
+		{`{"min_delay": "soon"}`, http.StatusBadRequest, 20 * time.Millisecond, 2},
+		{`{"min_delay": "-1s"}`, http.StatusConflict, 20 * time.Millisecond, 2},
+	}
+	for _, test := range tests {
+		if status := doAdminRequest(t, server, http.MethodPost, "/politeness", "", test.body, nil); status != test.status {
+			t.Errorf("POST /politeness %s: status = %d, want %d", test.body, status, test.status)
+		}
+		minDelay, maxConcurrency, err := sched.PolitenessLimits()
+		if err != nil || minDelay != test.minDelay || maxConcurrency != test.maxConcurrency {
+			t.Errorf("after %s: limits = %s, %d, %v, want %s, %d", test.body, minDelay, maxConcurrency, err,
+				test.minDelay, test.maxConcurrency)
+		}
+	}
+}
@@ -1,22 +1,28 @@
 package crawler
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"gocrawler/base"
 	"gocrawler/middleware"
+	"io"
 	"reflect"
 )
 
-type parseResponse func(res base.Response) ([]base.Data, []error)
+type ParseResponse func(res base.Response) ([]base.Data, []error)
 
 type Analyzer interface {
 	Id() uint32
-	Analyze(parser []parseResponse, res base.Response) ([]base.Data, []error)
+	Analyze(parser []ParseResponse, res base.Response) ([]base.Data, []error)
+	//analyze with a context, the remaining parsers are skipped once the context is done
+	AnalyzeContext(ctx context.Context, parser []ParseResponse, res base.Response) ([]base.Data, []error)
 }
 
 type AnalyzerPool interface {
 	Take() (Analyzer, error)
+	TakeContext(ctx context.Context) (Analyzer, error)
 	Return(Analyzer) error
 	Total() uint32
 	Used() uint32
@@ -51,7 +57,9 @@ func appendDataList(dataList []base.Data, data base.Data, depth uint32) []base.D
 	}
 	newDepth := depth + 1
 	if req.Depth() != newDepth {
+		session := req.Session()
 		req = base.NewRequest(req.Get(), newDepth)
+		req.SetSession(session)
 	}
 	return append(dataList, req)
 }
@@ -63,7 +71,11 @@ func appendErrorList(errorList []error, err error) []error {
 	return append(errorList, err)
 }
 
-func (m *myAnalyzer) Analyze(parser []parseResponse, res base.Response) ([]base.Data, []error) {
+func (m *myAnalyzer) Analyze(parser []ParseResponse, res base.Response) ([]base.Data, []error) {
+	return m.AnalyzeContext(context.Background(), parser, res)
+}
+
+func (m *myAnalyzer) AnalyzeContext(ctx context.Context, parser []ParseResponse, res base.Response) ([]base.Data, []error) {
 	if parser == nil {
 		errMsg := "The response parser is nil!"
 		return nil, []error{errors.New(errMsg)}
@@ -74,12 +86,30 @@ func (m *myAnalyzer) Analyze(parser []parseResponse, res base.Response) ([]base.
 	}
 	result := make([]base.Data, 0)
 	errResult := make([]error, 0)
+	//each parser reads the body from the start, so the body is buffered if it's read more than once
+	var body []byte
+	if len(parser) > 1 {
+		httpRes := res.Get()
+		var err error
+		body, err = io.ReadAll(httpRes.Body)
+		httpRes.Body.Close()
+		if err != nil {
+			return nil, []error{err}
+		}
+	}
 	for i, p := range parser {
+		if err := ctx.Err(); err != nil {
+			errResult = append(errResult, err)
+			break
+		}
 		if p == nil {
 			err := errors.New(fmt.Sprintf("The response parser is nil! Index: %d", i))
 			errResult = append(errResult, err)
 			continue
 		}
+		if body != nil {
+			res.Get().Body = io.NopCloser(bytes.NewReader(body))
+		}
 		datas, errs := p(res)
 		for _, data := range datas {
 			result = appendDataList(result, data, res.Depth())
@@ -117,7 +147,11 @@ func NewAnalyzerPool(
 }
 
 func (m *myAnalyzerPool) Take() (Analyzer, error) {
-	ana, err := m.pool.Take()
+	return m.TakeContext(context.Background())
+}
+
+func (m *myAnalyzerPool) TakeContext(ctx context.Context) (Analyzer, error) {
+	ana, err := m.pool.TakeContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -2,6 +2,13 @@ package base
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
 )
 
 type ErrorType string
@@ -10,16 +17,47 @@ const (
 	DOWNLOADER_ERROR     ErrorType = "Downloader Error"
 	ANALYZER_ERROR       ErrorType = "Analyzer Error"
 	ITEM_PROCESSOR_ERROR ErrorType = "Item Processor Error"
+	ROBOTS_ERROR         ErrorType = "Robots Error"
+	SCHEDULER_ERROR      ErrorType = "Scheduler Error"
+	SCOPE_ERROR          ErrorType = "Scope Error"
+	SINK_ERROR           ErrorType = "Sink Error"
+	LOGIN_ERROR          ErrorType = "Login Error"
 )
 
 type CrawlerError interface {
 	Type() ErrorType
 	Error() string
+	//get the underlying error, nil if there is none. It makes errors.Is and errors.As see the cause
+	Unwrap() error
+	//get the url of the request the error occurred on, empty if unknown
+	Url() string
+	//get the depth of the request
+	Depth() uint32
+	//get the code of the component which reported the error, e.g. "downloader-3"
+	ComponentId() string
+	//get the attempt of the request, 0 if unknown
+	Attempt() uint32
+	//get the time the error occurred
+	Time() time.Time
+	//whether the request may succeed if it's crawled again, a fatal error never will
+	Retryable() bool
+}
+
+// ErrorDetail is the optional info of a crawler error
+type ErrorDetail struct {
+	Cause       error
+	Url         string
+	Depth       uint32
+	ComponentId string
+	Attempt     uint32
+	Retryable   bool
 }
 
 type myCrawlerError struct {
 	errType    ErrorType
 	errMsg     string
+	detail     ErrorDetail
+	time       time.Time
 	fullErrMsg string
 }
 
@@ -42,12 +80,120 @@ func (c *myCrawlerError) genFullMsg() {
 		buf.WriteString(":")
 	}
 	buf.WriteString(c.errMsg)
+	if c.detail.Url != "" {
+		buf.WriteString(fmt.Sprintf(" (url=%s, depth=%d", c.detail.Url, c.detail.Depth))
+		if c.detail.Attempt > 0 {
+			buf.WriteString(fmt.Sprintf(", attempt=%d", c.detail.Attempt))
+		}
+		buf.WriteString(")")
+	}
 	c.fullErrMsg = buf.String()
 }
 
+func (c *myCrawlerError) Unwrap() error {
+	return c.detail.Cause
+}
+
+func (c *myCrawlerError) Url() string {
+	return c.detail.Url
+}
+
+func (c *myCrawlerError) Depth() uint32 {
+	return c.detail.Depth
+}
+
+func (c *myCrawlerError) ComponentId() string {
+	return c.detail.ComponentId
+}
+
+func (c *myCrawlerError) Attempt() uint32 {
+	return c.detail.Attempt
+}
+
+func (c *myCrawlerError) Time() time.Time {
+	return c.time
+}
+
+func (c *myCrawlerError) Retryable() bool {
+	return c.detail.Retryable
+}
+
 func NewCrawlerError(errType ErrorType, errMsg string) CrawlerError {
+	return NewCrawlerErrorWithDetail(errType, errMsg, ErrorDetail{})
+}
+
+// NewCrawlerErrorWithDetail creates an error with the detail, the message of the cause
+// is used if errMsg is empty
+func NewCrawlerErrorWithDetail(errType ErrorType, errMsg string, detail ErrorDetail) CrawlerError {
+	if errMsg == "" && detail.Cause != nil {
+		errMsg = detail.Cause.Error()
+	}
 	return &myCrawlerError{
 		errType: errType,
 		errMsg:  errMsg,
+		detail:  detail,
+		time:    time.Now(),
+	}
+}
+
+// CompleteCrawlerError returns a copy of the error whose empty detail fields are taken
+// from the given detail, the type, message, cause, time and retryable flag are kept
+func CompleteCrawlerError(err CrawlerError, detail ErrorDetail) CrawlerError {
+	result := &myCrawlerError{
+		errType: err.Type(),
+		detail: ErrorDetail{
+			Cause:       err.Unwrap(),
+			Url:         err.Url(),
+			Depth:       err.Depth(),
+			ComponentId: err.ComponentId(),
+			Attempt:     err.Attempt(),
+			Retryable:   err.Retryable(),
+		},
+		time: err.Time(),
+	}
+	if e, ok := err.(*myCrawlerError); ok {
+		result.errMsg = e.errMsg
+	} else {
+		result.errMsg = err.Error()
+	}
+	if result.detail.Url == "" {
+		result.detail.Url = detail.Url
+		result.detail.Depth = detail.Depth
+	}
+	if result.detail.ComponentId == "" {
+		result.detail.ComponentId = detail.ComponentId
+	}
+	if result.detail.Attempt == 0 {
+		result.detail.Attempt = detail.Attempt
+	}
+	return result
+}
+
+// IsRetryable tells whether the request failed with the error may succeed later,
+// e.g. on timeouts and broken connections
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var cError CrawlerError
+	if errors.As(err, &cError) {
+		return cError.Retryable()
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary
 	}
+	return false
 }
@@ -0,0 +1,55 @@
+package base
+
+import (
+	"net/url"
+	"strings"
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// NormalizeUrl returns the canonical form of the url, so that urls referring to the same
+// resource are equal: scheme and host are lower cased, default port and fragment are removed,
+// an empty path becomes "/" and query parameters are sorted by key
+func NormalizeUrl(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	normalized := *u
+	normalized.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	if port := u.Port(); port != "" && defaultPorts[normalized.Scheme] == port {
+		host = strings.TrimSuffix(host, ":"+port)
+	}
+	normalized.Host = host
+	normalized.Fragment = ""
+	normalized.RawFragment = ""
+	if normalized.Path == "" && normalized.Opaque == "" {
+		normalized.Path = "/"
+		normalized.RawPath = ""
+	}
+	if u.RawQuery != "" {
+		//keep the raw query if it can not be parsed, otherwise the parameters would be lost
+		if values, err := url.ParseQuery(u.RawQuery); err == nil {
+			normalized.RawQuery = values.Encode()
+		}
+	}
+	normalized.ForceQuery = false
+	return normalized.String()
+}
+
+// RequestKey identifies a request for de-duplication, requests with methods other than GET
+// are distinguished from the GET request of the same url
+func RequestKey(req *Request) string {
+	if req == nil || !req.Valid() {
+		return ""
+	}
+	httpReq := req.Get()
+	key := NormalizeUrl(httpReq.URL)
+	if httpReq.Method != "" && httpReq.Method != "GET" {
+		key = httpReq.Method + " " + key
+	}
+	return key
+}
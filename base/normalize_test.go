@@ -0,0 +1,64 @@
+package base
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestNormalizeUrl(t *testing.T) {
+	tests := []struct {
+		rawUrl string
+		want   string
+	}{
+		{"http://example.com", "http://example.com/"},
+		{"HTTP://Example.COM/Path", "http://example.com/Path"},
+		{"http://example.com:80/", "http://example.com/"},
+		{"https://example.com:443/", "https://example.com/"},
+		{"http://example.com:443/", "http://example.com:443/"},
+		{"https://example.com:8443/a", "https://example.com:8443/a"},
+		{"http://example.com/a#top", "http://example.com/a"},
+		{"http://example.com/a?", "http://example.com/a"},
+		{"http://example.com/a?b=2&a=1", "http://example.com/a?a=1&b=2"},
+		{"http://example.com/a?a=2&a=1", "http://example.com/a?a=2&a=1"},
+		{"http://example.com/a?%zz", "http://example.com/a?%zz"},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.rawUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := NormalizeUrl(u); got != test.want {
+			t.Errorf("NormalizeUrl(%q) = %q, want %q", test.rawUrl, got, test.want)
+		}
+	}
+	if got := NormalizeUrl(nil); got != "" {
+		t.Errorf("NormalizeUrl(nil) = %q, want \"\"", got)
+	}
+}
+
+func TestRequestKey(t *testing.T) {
+	tests := []struct {
+		method string
+		rawUrl string
+		want   string
+	}{
+		{"GET", "http://Example.com#a", "http://example.com/"},
+		{"", "http://example.com/a", "http://example.com/a"},
+		{"POST", "http://example.com/a", "POST http://example.com/a"},
+		{"HEAD", "http://example.com/a?b=1&a=2", "HEAD http://example.com/a?a=2&b=1"},
+	}
+	for _, test := range tests {
+		httpReq, err := http.NewRequest(test.method, test.rawUrl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpReq.Method = test.method
+		if got := RequestKey(NewRequest(httpReq, 0)); got != test.want {
+			t.Errorf("RequestKey(%s %q) = %q, want %q", test.method, test.rawUrl, got, test.want)
+		}
+	}
+	if got := RequestKey(nil); got != "" {
+		t.Errorf("RequestKey(nil) = %q, want \"\"", got)
+	}
+}
@@ -7,6 +7,10 @@ import (
 type Request struct {
 	httpReq *http.Request
 	depth   uint32
+	//the number of times the request has been sent, 0 means not sent yet
+	attempt uint32
+	//the cookie session the request belongs to, empty means the shared one
+	session string
 }
 
 func NewRequest(httpReq *http.Request, depth uint32) *Request {
@@ -24,6 +28,22 @@ func (r *Request) Depth() uint32 {
 	return r.depth
 }
 
+func (r *Request) Attempt() uint32 {
+	return r.attempt
+}
+
+func (r *Request) SetAttempt(attempt uint32) {
+	r.attempt = attempt
+}
+
+func (r *Request) Session() string {
+	return r.session
+}
+
+func (r *Request) SetSession(session string) {
+	r.session = session
+}
+
 func (r *Request) Valid() bool {
 	return r.httpReq != nil && r.httpReq.URL != nil
 }
@@ -7,6 +7,14 @@ import (
 type Response struct {
 	response *http.Response
 	depth    uint32
+	//the attempt of the request which got this response, starts from 1
+	attempt uint32
+	//the cookie session of the request which got this response
+	session string
+	//whether the response is served from the http cache
+	cached bool
+	//whether the page is the same as the cached one
+	unchanged bool
 }
 
 func NewResponse(response *http.Response, depth uint32) *Response {
@@ -24,6 +32,42 @@ func (res *Response) Depth() uint32 {
 	return res.depth
 }
 
+func (res *Response) Attempt() uint32 {
+	return res.attempt
+}
+
+func (res *Response) SetAttempt(attempt uint32) {
+	res.attempt = attempt
+}
+
+func (res *Response) Session() string {
+	return res.session
+}
+
+func (res *Response) SetSession(session string) {
+	res.session = session
+}
+
+func (res *Response) Cached() bool {
+	return res.cached
+}
+
+// SetCached flags the response as served from the http cache, so it's unchanged too
+func (res *Response) SetCached(cached bool) {
+	res.cached = cached
+	if cached {
+		res.unchanged = true
+	}
+}
+
+func (res *Response) Unchanged() bool {
+	return res.unchanged
+}
+
+func (res *Response) SetUnchanged(unchanged bool) {
+	res.unchanged = unchanged
+}
+
 func (res *Response) Valid() bool {
 	return res.response != nil && res.response.Body != nil
 }
@@ -0,0 +1,349 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gocrawler/base"
+	"gocrawler/middleware"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the files of a checkpoint generation
+const (
+	CHECKPOINT_FRONTIER_FILE = "frontier.json"
+	CHECKPOINT_SEEN_FILE     = "seen"
+	CHECKPOINT_COUNTERS_FILE = "counters.json"
+	CHECKPOINT_SEGMENTS_FILE = "segments.json"
+)
+
+// each checkpoint is written to a generation directory of its own, the manifest in the
+// checkpoint directory names the last complete one
+const (
+	CHECKPOINT_MANIFEST_FILE     = "manifest.json"
+	CHECKPOINT_GENERATION_PREFIX = "generation-"
+)
+
+// the request headers which are never saved, the credentials should be set by a downloader
+// middleware instead
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// savedRequest is the serialized form of a request in checkpoints and on-disk queues,
+// the body and the sensitive headers are not saved
+type savedRequest struct {
+	Method string      `json:"method"`
+	Url    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Depth  uint32      `json:"depth"`
+	//the cookie session of the request
+	Session string `json:"session,omitempty"`
+}
+
+func newSavedRequest(req *base.Request) savedRequest {
+	httpReq := req.Get()
+	return savedRequest{
+		Method:  httpReq.Method,
+		Url:     httpReq.URL.String(),
+		Header:  withoutSensitiveHeaders(httpReq.Header),
+		Depth:   req.Depth(),
+		Session: req.Session(),
+	}
+}
+
+// withoutSensitiveHeaders returns a copy of the header without the sensitive headers
+func withoutSensitiveHeaders(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range sensitiveHeaders {
+		header.Del(name)
+	}
+	return header
+}
+
+func (s savedRequest) request() (*base.Request, error) {
+	httpReq, err := http.NewRequest(s.Method, s.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.Header != nil {
+		httpReq.Header = s.Header
+	}
+	req := base.NewRequest(httpReq, s.Depth)
+	req.SetSession(s.Session)
+	return req, nil
+}
+
+type checkpointCounters struct {
+	Time           time.Time `json:"time"`
+	Sent           uint64    `json:"sent"`
+	Accepted       uint64    `json:"accepted"`
+	Processed      uint64    `json:"processed"`
+	Duplicates     uint64    `json:"duplicates"`
+	Retries        uint64    `json:"retries"`
+	Downloaded     uint64    `json:"downloaded"`
+	Analyzed       uint64    `json:"analyzed"`
+	ItemsProcessed uint64    `json:"items_processed"`
+	//the number of urls rejected by the scope by reason
+	ScopeRejected map[string]uint64 `json:"scope_rejected,omitempty"`
+}
+
+// savedSegment is a segment file of the on-disk queue, the file is named relative to the
+// queue directory and only its first Count requests belong to the checkpoint
+type savedSegment struct {
+	File  string `json:"file"`
+	Count int    `json:"count"`
+}
+
+type checkpointManifest struct {
+	Generation string    `json:"generation"`
+	Time       time.Time `json:"time"`
+}
+
+// checkpointState is what a checkpoint saves
+type checkpointState struct {
+	//the requests being crawled and the ones waiting in memory
+	reqs []*base.Request
+	//the segment files holding the other waiting requests
+	segments []savedSegment
+	counters checkpointCounters
+	//the saved seen set, nil if the seen set can't be saved
+	seen []byte
+}
+
+// outstandingRequests tracks the requests from being taken from the request cache until
+// their responses are analyzed, they are what a checkpoint has to crawl again
+type outstandingRequests struct {
+	reqs  map[string]*base.Request
+	mutex sync.Mutex
+}
+
+func newOutstandingRequests() *outstandingRequests {
+	return &outstandingRequests{reqs: make(map[string]*base.Request)}
+}
+
+func (o *outstandingRequests) add(req *base.Request) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.reqs[base.RequestKey(req)] = req
+}
+
+func (o *outstandingRequests) remove(key string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	delete(o.reqs, key)
+}
+
+func (o *outstandingRequests) list() []*base.Request {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	result := make([]*base.Request, 0, len(o.reqs))
+	for _, req := range o.reqs {
+		result = append(result, req)
+	}
+	return result
+}
+
+// the response of a redirected request belongs to the request sent first
+func originalRequestKey(res base.Response) string {
+	httpRes := res.Get()
+	if httpRes == nil || httpRes.Request == nil {
+		return ""
+	}
+	httpReq := httpRes.Request
+	for httpReq.Response != nil && httpReq.Response.Request != nil {
+		httpReq = httpReq.Response.Request
+	}
+	return base.RequestKey(base.NewRequest(httpReq, res.Depth()))
+}
+
+// write the file by renaming a temporary file, so a crash never leaves a partial file
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// snapshotSeenSet saves the seen set to memory, nil is returned if it's not persistent
+func snapshotSeenSet(seenSet middleware.SeenSet) ([]byte, error) {
+	persistent, ok := seenSet.(middleware.PersistentSeenSet)
+	if !ok {
+		return nil, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0))
+	if err := persistent.Save(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJsonFile(path string, value interface{}) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(value)
+	})
+}
+
+// the entries of a directory are only durable once the directory itself is synced
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// saveCheckpoint writes the state to a new generation directory and then points the manifest
+// to it, so a crash in between leaves the last checkpoint intact. The older generations are
+// removed once the manifest is written
+func saveCheckpoint(dir string, state checkpointState) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	genDir, err := os.MkdirTemp(dir, CHECKPOINT_GENERATION_PREFIX)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			os.RemoveAll(genDir)
+		}
+	}()
+	if state.seen != nil {
+		err := writeFileAtomic(filepath.Join(genDir, CHECKPOINT_SEEN_FILE), func(w io.Writer) error {
+			_, err := w.Write(state.seen)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	frontier := make([]savedRequest, 0, len(state.reqs))
+	for _, req := range state.reqs {
+		frontier = append(frontier, newSavedRequest(req))
+	}
+	segments := state.segments
+	if segments == nil {
+		segments = []savedSegment{}
+	}
+	if err := writeJsonFile(filepath.Join(genDir, CHECKPOINT_SEGMENTS_FILE), segments); err != nil {
+		return err
+	}
+	if err := writeJsonFile(filepath.Join(genDir, CHECKPOINT_FRONTIER_FILE), frontier); err != nil {
+		return err
+	}
+	if err := writeJsonFile(filepath.Join(genDir, CHECKPOINT_COUNTERS_FILE), state.counters); err != nil {
+		return err
+	}
+	if err := syncDir(genDir); err != nil {
+		return err
+	}
+	manifest := checkpointManifest{Generation: filepath.Base(genDir), Time: state.counters.Time}
+	if err := writeJsonFile(filepath.Join(dir, CHECKPOINT_MANIFEST_FILE), manifest); err != nil {
+		return err
+	}
+	committed = true
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() && strings.HasPrefix(name, CHECKPOINT_GENERATION_PREFIX) && name != manifest.Generation {
+			os.RemoveAll(filepath.Join(dir, name))
+		}
+	}
+	return nil
+}
+
+// loadCheckpoint restores the seen set and returns the rest of the generation named by the
+// manifest, nil if the directory has no checkpoint
+func loadCheckpoint(dir string, seenSet middleware.SeenSet) (*checkpointState, error) {
+	manifestFile, err := os.Open(filepath.Join(dir, CHECKPOINT_MANIFEST_FILE))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest checkpointManifest
+	err = json.NewDecoder(manifestFile).Decode(&manifest)
+	manifestFile.Close()
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Generation == "" || filepath.Base(manifest.Generation) != manifest.Generation {
+		errMsg := fmt.Sprintf("Invalid checkpoint generation! (generation=%s)", manifest.Generation)
+		return nil, errors.New(errMsg)
+	}
+	dir = filepath.Join(dir, manifest.Generation)
+	frontierFile, err := os.Open(filepath.Join(dir, CHECKPOINT_FRONTIER_FILE))
+	if err != nil {
+		return nil, err
+	}
+	defer frontierFile.Close()
+	var frontier []savedRequest
+	if err := json.NewDecoder(frontierFile).Decode(&frontier); err != nil {
+		return nil, err
+	}
+	state := &checkpointState{}
+	if segmentsFile, err := os.Open(filepath.Join(dir, CHECKPOINT_SEGMENTS_FILE)); err == nil {
+		err = json.NewDecoder(segmentsFile).Decode(&state.segments)
+		segmentsFile.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if persistent, ok := seenSet.(middleware.PersistentSeenSet); ok {
+		seenFile, err := os.Open(filepath.Join(dir, CHECKPOINT_SEEN_FILE))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			err = persistent.Load(seenFile)
+			seenFile.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if countersFile, err := os.Open(filepath.Join(dir, CHECKPOINT_COUNTERS_FILE)); err == nil {
+		err = json.NewDecoder(countersFile).Decode(&state.counters)
+		countersFile.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	state.reqs = make([]*base.Request, 0, len(frontier))
+	for _, saved := range frontier {
+		req, err := saved.request()
+		if err != nil {
+			return nil, errors.New("Invalid request in checkpoint: " + err.Error())
+		}
+		state.reqs = append(state.reqs, req)
+	}
+	return state, nil
+}
@@ -0,0 +1,277 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gocrawler/base"
+	"gocrawler/middleware"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHoldServer serves the pages of newTestSiteServer, the held pages don't respond
+// until release is closed
+type testHoldServer struct {
+	*httptest.Server
+	held    map[string]bool
+	release chan struct{}
+	//the number of requests being held
+	holding int
+	served  map[string]int
+	mutex   sync.Mutex
+}
+
+func newTestHoldServer(held ...string) *testHoldServer {
+	server := &testHoldServer{
+		held:    make(map[string]bool),
+		release: make(chan struct{}),
+		served:  make(map[string]int),
+	}
+	for _, path := range held {
+		server.held[path] = true
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if server.held[r.URL.Path] {
+			server.mutex.Lock()
+			server.holding++
+			server.mutex.Unlock()
+			select {
+			case <-server.release:
+			case <-r.Context().Done():
+			}
+			server.mutex.Lock()
+			server.holding--
+			server.mutex.Unlock()
+			if r.Context().Err() != nil {
+				return
+			}
+		}
+		var n int
+		fmt.Sscanf(r.URL.Path, "/p%d", &n)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><a href="/p%d">a</a><a href="/p%d">b</a></html>`, n+1, n+2)
+		server.mutex.Lock()
+		server.served[r.URL.Path]++
+		server.mutex.Unlock()
+	}))
+	return server
+}
+
+// waitHolding waits until count requests are held
+func (s *testHoldServer) waitHolding(t *testing.T, count int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mutex.Lock()
+		holding := s.holding
+		s.mutex.Unlock()
+		if holding == count {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests are held, want %d", holding, count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// servedPaths returns the sorted paths served since the last call
+func (s *testHoldServer) servedPaths() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	paths := make([]string, 0, len(s.served))
+	for path, count := range s.served {
+		for i := 0; i < count; i++ {
+			paths = append(paths, path)
+		}
+	}
+	s.served = make(map[string]int)
+	sort.Strings(paths)
+	return paths
+}
+
+func TestCheckpointResume(t *testing.T) {
+	server := newTestHoldServer("/p3", "/p4")
+	defer server.Close()
+	dir := t.TempDir()
+
+	sched := NewScheduler(WithCheckpoint(dir, 0))
+	startTestScheduler(t, sched, context.Background(), server.Server, 3)
+	//p0, p1 and p2 are crawled, the downloads of p3 and p4 are held until stopping
+	server.waitHolding(t, 2)
+	sched.Stop()
+	server.waitHolding(t, 0)
+	if got := strings.Join(server.servedPaths(), " "); got != "/p0 /p1 /p2" {
+		t.Fatalf("served before stopping: %s, want /p0 /p1 /p2", got)
+	}
+	close(server.release)
+
+	resumed := NewScheduler(WithCheckpoint(dir, 0))
+	startTestScheduler(t, resumed, context.Background(), server.Server, 3)
+	waitIdle(t, resumed, 5*time.Second)
+	resumed.Stop()
+	if got := strings.Join(server.servedPaths(), " "); got != "/p3 /p4 /p5 /p6" {
+		t.Errorf("served after resuming: %s, want /p3 /p4 /p5 /p6", got)
+	}
+	if summary := resumed.Summary(""); summary.Downloader.Completed != 7 {
+		t.Errorf("downloaded counter after resuming = %d, want 7", summary.Downloader.Completed)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("%d entries in the checkpoint directory, want the manifest and one generation", len(entries))
+	}
+}
+
+// newTestCheckpointScheduler builds the parts of a scheduler used by acceptRequest and checkpoint
+func newTestCheckpointScheduler(dir string) *myScheduler {
+	return &myScheduler{
+		crawlDepth:    10,
+		stopSign:      middleware.NewStopSign(),
+		seenSet:       middleware.NewMemorySeenSet(),
+		reqCache:      newRequestCache(),
+		outstanding:   newOutstandingRequests(),
+		itemPipeline:  NewItemPipeline([]ProcessItem{}),
+		checkpointDir: dir,
+	}
+}
+
+// readCheckpointGeneration returns the urls of the frontier and the keys of the seen set
+// of the generation named by the manifest
+func readCheckpointGeneration(t *testing.T, dir string) (map[string]bool, []string) {
+	content, err := os.ReadFile(filepath.Join(dir, CHECKPOINT_MANIFEST_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest checkpointManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	genDir := filepath.Join(dir, manifest.Generation)
+	content, err = os.ReadFile(filepath.Join(genDir, CHECKPOINT_FRONTIER_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frontier []savedRequest
+	if err := json.Unmarshal(content, &frontier); err != nil {
+		t.Fatal(err)
+	}
+	urls := make(map[string]bool, len(frontier))
+	for _, saved := range frontier {
+		urls[saved.Url] = true
+	}
+	seenFile, err := os.Open(filepath.Join(genDir, CHECKPOINT_SEEN_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seenFile.Close()
+	keys := make([]string, 0)
+	scanner := bufio.NewScanner(seenFile)
+	for scanner.Scan() {
+		keys = append(keys, scanner.Text())
+	}
+	return urls, keys
+}
+
+// slowSeenSet widens the gap between adding a request to the seen set and to the cache
+type slowSeenSet struct {
+	middleware.PersistentSeenSet
+}
+
+func (s slowSeenSet) Add(key string) bool {
+	added := s.PersistentSeenSet.Add(key)
+	time.Sleep(100 * time.Microsecond)
+	return added
+}
+
+func TestCheckpointSeenSetMatchesFrontier(t *testing.T) {
+	dir := t.TempDir()
+	sched := newTestCheckpointScheduler(dir)
+	sched.seenSet = slowSeenSet{middleware.NewMemorySeenSet()}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 300; i++ {
+			req := newTestRequest(t, fmt.Sprintf("http://example.com/%d", i), 1)
+			if err := sched.acceptRequest(*req, nil); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		if err := sched.checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+		//nothing is taken from the cache, so every seen url must be in the frontier
+		urls, keys := readCheckpointGeneration(t, dir)
+		for _, key := range keys {
+			if !urls[key] {
+				t.Fatalf("%s is in the saved seen set but not in the saved frontier", key)
+			}
+		}
+		if len(keys) != len(urls) {
+			t.Fatalf("%d urls in the saved seen set, %d in the saved frontier", len(keys), len(urls))
+		}
+	}
+}
+
+func TestCheckpointGeneration(t *testing.T) {
+	dir := t.TempDir()
+	state := checkpointState{
+		reqs:     []*base.Request{newTestRequest(t, "http://example.com/a", 1)},
+		counters: checkpointCounters{Downloaded: 3},
+	}
+	if err := saveCheckpoint(dir, state); err != nil {
+		t.Fatal(err)
+	}
+	//a generation interrupted before the manifest is written is ignored and removed later
+	stale := filepath.Join(dir, CHECKPOINT_GENERATION_PREFIX+"crashed")
+	if err := os.Mkdir(stale, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(stale, CHECKPOINT_FRONTIER_FILE), []byte("[{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadCheckpoint(dir, middleware.NewMemorySeenSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.reqs) != 1 || loaded.reqs[0].Get().URL.String() != "http://example.com/a" ||
+		loaded.counters.Downloaded != 3 {
+		t.Errorf("loaded checkpoint = %d requests and %d downloaded, want the saved one",
+			len(loaded.reqs), loaded.counters.Downloaded)
+	}
+	state.reqs = append(state.reqs, newTestRequest(t, "http://example.com/b", 1))
+	if err := saveCheckpoint(dir, state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("the interrupted generation is not removed")
+	}
+	loaded, err = loadCheckpoint(dir, middleware.NewMemorySeenSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.reqs) != 2 {
+		t.Errorf("%d requests in the latest checkpoint, want 2", len(loaded.reqs))
+	}
+	if empty, err := loadCheckpoint(t.TempDir(), middleware.NewMemorySeenSet()); empty != nil || err != nil {
+		t.Errorf("loadCheckpoint of an empty directory = %v, %v, want nil, nil", empty, err)
+	}
+}
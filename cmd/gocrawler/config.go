@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gocrawler"
+	"gopkg.in/yaml.v3"
+)
+
+// duration is written as a string like "1m30s" in config files
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalText(text []byte) error {
+	value, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = value
+	return nil
+}
+
+type scopeConfig struct {
+	AllowedDomains    []string `json:"allowed_domains" yaml:"allowed_domains" toml:"allowed_domains"`
+	SameOriginAsSeed  bool     `json:"same_origin_as_seed" yaml:"same_origin_as_seed" toml:"same_origin_as_seed"`
+	IncludePatterns   []string `json:"include_patterns" yaml:"include_patterns" toml:"include_patterns"`
+	ExcludePatterns   []string `json:"exclude_patterns" yaml:"exclude_patterns" toml:"exclude_patterns"`
+	PathPrefixes      []string `json:"path_prefixes" yaml:"path_prefixes" toml:"path_prefixes"`
+	MaxQueryParams    int      `json:"max_query_params" yaml:"max_query_params" toml:"max_query_params"`
+	ExcludeExtensions []string `json:"exclude_extensions" yaml:"exclude_extensions" toml:"exclude_extensions"`
+}
+
+type politenessConfig struct {
+	MinDelay        duration `json:"min_delay" yaml:"min_delay" toml:"min_delay"`
+	MaxConcurrency  uint32   `json:"max_concurrency" yaml:"max_concurrency" toml:"max_concurrency"`
+	PerIp           bool     `json:"per_ip" yaml:"per_ip" toml:"per_ip"`
+	HonorCrawlDelay bool     `json:"honor_crawl_delay" yaml:"honor_crawl_delay" toml:"honor_crawl_delay"`
+}
+
+type sessionsConfig struct {
+	Isolated   bool   `json:"isolated" yaml:"isolated" toml:"isolated"`
+	CookieFile string `json:"cookie_file" yaml:"cookie_file" toml:"cookie_file"`
+}
+
+type loginConfig struct {
+	LoginUrl     string `json:"login_url" yaml:"login_url" toml:"login_url"`
+	FormSelector string `json:"form_selector" yaml:"form_selector" toml:"form_selector"`
+	//the environment variables in the values are expanded, e.g. "${CRAWL_PASSWORD}"
+	Fields             map[string]string `json:"fields" yaml:"fields" toml:"fields"`
+	SuccessStatus      int               `json:"success_status" yaml:"success_status" toml:"success_status"`
+	SuccessUrlPattern  string            `json:"success_url_pattern" yaml:"success_url_pattern" toml:"success_url_pattern"`
+	SuccessBodyPattern string            `json:"success_body_pattern" yaml:"success_body_pattern" toml:"success_body_pattern"`
+	ExpiredStatus      []int             `json:"expired_status" yaml:"expired_status" toml:"expired_status"`
+	ExpiredUrlPattern  string            `json:"expired_url_pattern" yaml:"expired_url_pattern" toml:"expired_url_pattern"`
+	ExpiredBodyPattern string            `json:"expired_body_pattern" yaml:"expired_body_pattern" toml:"expired_body_pattern"`
+}
+
+type outputConfig struct {
+	//stdout or file
+	Type string `json:"type" yaml:"type" toml:"type"`
+	//the file the items are appended to as json lines, only used by the file output
+	Path string `json:"path" yaml:"path" toml:"path"`
+}
+
+type config struct {
+	Seeds []string `json:"seeds" yaml:"seeds" toml:"seeds"`
+	//a file with one seed url per line, the lines starting with # are skipped
+	SeedFile   string `json:"seed_file" yaml:"seed_file" toml:"seed_file"`
+	Depth      uint32 `json:"depth" yaml:"depth" toml:"depth"`
+	PoolSize   uint32 `json:"pool_size" yaml:"pool_size" toml:"pool_size"`
+	ChannelLen uint32 `json:"channel_len" yaml:"channel_len" toml:"channel_len"`
+	UserAgent  string `json:"user_agent" yaml:"user_agent" toml:"user_agent"`
+	//the timeout of each http request
+	RequestTimeout duration `json:"request_timeout" yaml:"request_timeout" toml:"request_timeout"`
+	//whether the links in pages are followed, true by default
+	FollowLinks *bool `json:"follow_links" yaml:"follow_links" toml:"follow_links"`
+	//whether robots.txt is obeyed
+	Robots     bool              `json:"robots" yaml:"robots" toml:"robots"`
+	Scope      *scopeConfig      `json:"scope" yaml:"scope" toml:"scope"`
+	Politeness *politenessConfig `json:"politeness" yaml:"politeness" toml:"politeness"`
+	//the cookies are shared by the requests if it's set
+	Sessions *sessionsConfig `json:"sessions" yaml:"sessions" toml:"sessions"`
+	//the crawl logs in with the form before the seeds are crawled if it's set
+	Login *loginConfig `json:"login" yaml:"login" toml:"login"`
+	//the rules are used together with the ones in the extract rule file
+	ExtractRules     []crawler.ExtractRule `json:"extract_rules" yaml:"extract_rules" toml:"extract_rules"`
+	ExtractRulesFile string                `json:"extract_rules_file" yaml:"extract_rules_file" toml:"extract_rules_file"`
+	Output           outputConfig          `json:"output" yaml:"output" toml:"output"`
+	CheckpointDir    string                `json:"checkpoint_dir" yaml:"checkpoint_dir" toml:"checkpoint_dir"`
+	//the responses are cached in the dir and revalidated on later crawls if it's set
+	HttpCacheDir string `json:"http_cache_dir" yaml:"http_cache_dir" toml:"http_cache_dir"`
+	//the downloads are recorded to the archive file, or replayed from it instead of the network
+	RecordFile string `json:"record_file" yaml:"record_file" toml:"record_file"`
+	ReplayFile string `json:"replay_file" yaml:"replay_file" toml:"replay_file"`
+	//the interval of printing summaries, 0 means never
+	SummaryInterval duration `json:"summary_interval" yaml:"summary_interval" toml:"summary_interval"`
+	//how long the stages may take to finish their work on SIGINT or SIGTERM
+	DrainTimeout duration `json:"drain_timeout" yaml:"drain_timeout" toml:"drain_timeout"`
+	//the address of the admin http server, it's not started if empty
+	AdminAddr string `json:"admin_addr" yaml:"admin_addr" toml:"admin_addr"`
+	//the bearer token of the admin api, the environment variables are expanded, e.g.
+	//"${CRAWL_ADMIN_TOKEN}". It's required unless the admin server listens on loopback
+	AdminToken string `json:"admin_token" yaml:"admin_token" toml:"admin_token"`
+}
+
+// loadConfig reads the config from a json, yaml or toml file, the format is chosen by the extension
+func loadConfig(path string) (*config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conf := &config{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(content, conf)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, conf)
+	case ".toml":
+		err = toml.Unmarshal(content, conf)
+	default:
+		errMsg := fmt.Sprintf("Unsupported config file format! (path=%s)", path)
+		return nil, errors.New(errMsg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conf.setDefaults()
+	if err := conf.loadSeedFile(); err != nil {
+		return nil, err
+	}
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func (c *config) setDefaults() {
+	if c.PoolSize == 0 {
+		c.PoolSize = 10
+	}
+	if c.ChannelLen == 0 {
+		c.ChannelLen = 100
+	}
+	if c.RequestTimeout.Duration == 0 {
+		c.RequestTimeout.Duration = 30 * time.Second
+	}
+	if c.DrainTimeout.Duration == 0 {
+		c.DrainTimeout.Duration = 30 * time.Second
+	}
+	if c.FollowLinks == nil {
+		followLinks := true
+		c.FollowLinks = &followLinks
+	}
+	if c.Output.Type == "" {
+		c.Output.Type = "stdout"
+	}
+}
+
+func (c *config) loadSeedFile() error {
+	if c.SeedFile == "" {
+		return nil
+	}
+	content, err := os.ReadFile(c.SeedFile)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		c.Seeds = append(c.Seeds, line)
+	}
+	return nil
+}
+
+func (c *config) validate() error {
+	if len(c.Seeds) == 0 {
+		return errors.New("No seed is given!")
+	}
+	if c.RecordFile != "" && c.ReplayFile != "" {
+		return errors.New("The record file and the replay file can't both be set!")
+	}
+	if c.AdminAddr != "" && c.adminArgs().Token == "" {
+		host, _, err := net.SplitHostPort(c.AdminAddr)
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid admin address: %s", err)
+			return errors.New(errMsg)
+		}
+		if !isLoopbackHost(host) {
+			errMsg := fmt.Sprintf("The admin token is required to listen on %s!", c.AdminAddr)
+			return errors.New(errMsg)
+		}
+	}
+	switch c.Output.Type {
+	case "stdout":
+	case "file":
+		if c.Output.Path == "" {
+			return errors.New("The output path is empty!")
+		}
+	default:
+		return errors.New("Unknown output type: " + c.Output.Type)
+	}
+	return nil
+}
+
+// an empty host listens on all the interfaces, so it's not loopback
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (c *config) adminArgs() crawler.AdminArgs {
+	return crawler.AdminArgs{
+		Addr:  c.AdminAddr,
+		Token: os.ExpandEnv(c.AdminToken),
+	}
+}
+
+func (c *config) scopeArgs() crawler.ScopeArgs {
+	return crawler.ScopeArgs{
+		AllowedDomains:    c.Scope.AllowedDomains,
+		SameOriginAsSeed:  c.Scope.SameOriginAsSeed,
+		IncludePatterns:   c.Scope.IncludePatterns,
+		ExcludePatterns:   c.Scope.ExcludePatterns,
+		PathPrefixes:      c.Scope.PathPrefixes,
+		MaxQueryParams:    c.Scope.MaxQueryParams,
+		ExcludeExtensions: c.Scope.ExcludeExtensions,
+	}
+}
+
+func (c *config) loginArgs() crawler.LoginArgs {
+	fields := make(map[string]string, len(c.Login.Fields))
+	for name, value := range c.Login.Fields {
+		fields[name] = os.ExpandEnv(value)
+	}
+	return crawler.LoginArgs{
+		LoginUrl:           c.Login.LoginUrl,
+		FormSelector:       c.Login.FormSelector,
+		Fields:             fields,
+		SuccessStatus:      c.Login.SuccessStatus,
+		SuccessUrlPattern:  c.Login.SuccessUrlPattern,
+		SuccessBodyPattern: c.Login.SuccessBodyPattern,
+		ExpiredStatus:      c.Login.ExpiredStatus,
+		ExpiredUrlPattern:  c.Login.ExpiredUrlPattern,
+		ExpiredBodyPattern: c.Login.ExpiredBodyPattern,
+	}
+}
+
+func (c *config) politenessArgs() crawler.PolitenessArgs {
+	return crawler.PolitenessArgs{
+		MinDelay:        c.Politeness.MinDelay.Duration,
+		MaxConcurrency:  c.Politeness.MaxConcurrency,
+		PerIp:           c.Politeness.PerIp,
+		HonorCrawlDelay: c.Politeness.HonorCrawlDelay,
+	}
+}
@@ -0,0 +1,286 @@
+// Command gocrawler runs a crawl described by a config file:
+//
+//	gocrawler -config crawl.yaml
+//
+// The first SIGINT or SIGTERM drains the crawl gracefully, the second one stops it at once.
+// The exit code is 0 if the crawl finished without errors, 1 if errors occurred and 2 if
+// the crawl could not be started
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gocrawler"
+	"gocrawler/base"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	EXIT_OK     = 0
+	EXIT_ERRORS = 1
+	EXIT_FAILED = 2
+)
+
+// the crawl is finished once the scheduler has been idle for these checks in a row
+var (
+	idleCheckInterval = 200 * time.Millisecond
+	idleConfirmations = 5
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	configPath := flag.String("config", "", "the path of the json, yaml or toml config file")
+	flag.Parse()
+	if *configPath == "" {
+		fmt.Fprintln(os.Stderr, "The config file is required!")
+		flag.Usage()
+		return EXIT_FAILED
+	}
+	conf, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %s\n", err)
+		return EXIT_FAILED
+	}
+	output, closeOutput, err := openOutput(conf.Output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open output: %s\n", err)
+		return EXIT_FAILED
+	}
+	defer closeOutput()
+	resParsers, err := buildParsers(conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load extract rules: %s\n", err)
+		return EXIT_FAILED
+	}
+	seeds := make([]base.Request, 0, len(conf.Seeds))
+	for _, seed := range conf.Seeds {
+		httpReq, err := http.NewRequest(http.MethodGet, seed, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid seed %s: %s\n", seed, err)
+			return EXIT_FAILED
+		}
+		seeds = append(seeds, *base.NewRequest(httpReq, 0))
+	}
+
+	sched := crawler.NewScheduler(buildOptions(conf)...)
+	genHttpClient := func() *http.Client {
+		return &http.Client{
+			Timeout:   conf.RequestTimeout.Duration,
+			Transport: &userAgentTransport{userAgent: conf.UserAgent, next: http.DefaultTransport},
+		}
+	}
+	err = sched.StartSeeds(context.Background(), conf.ChannelLen, conf.PoolSize, conf.Depth,
+		genHttpClient, resParsers, []crawler.ProcessItem{output}, seeds)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start the crawl: %s\n", err)
+		return EXIT_FAILED
+	}
+
+	var errorCount uint64
+	onError := func(err error) {
+		atomic.AddUint64(&errorCount, 1)
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	var consumed sync.WaitGroup
+	consumed.Add(1)
+	if conf.AdminAddr != "" {
+		admin := crawler.NewAdminServer(sched, conf.adminArgs())
+		go func() {
+			defer consumed.Done()
+			admin.ConsumeErrors(onError)
+		}()
+		go func() {
+			if err := admin.ListenAndServe(); err != nil {
+				fmt.Fprintf(os.Stderr, "Admin server error: %s\n", err)
+			}
+		}()
+		defer admin.Shutdown(context.Background())
+	} else {
+		go func() {
+			defer consumed.Done()
+			for err := range sched.ErrorChan() {
+				onError(err)
+			}
+		}()
+	}
+
+	monitor(sched, conf)
+	consumed.Wait()
+	fmt.Fprint(os.Stderr, sched.Summary("").String())
+	if atomic.LoadUint64(&errorCount) > 0 {
+		return EXIT_ERRORS
+	}
+	return EXIT_OK
+}
+
+// monitor prints the summaries and returns once the crawl is stopped, it stops the crawl
+// when it's finished or a signal is received
+func monitor(sched crawler.Scheduler, conf *config) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	var summaryChan <-chan time.Time
+	if conf.SummaryInterval.Duration > 0 {
+		ticker := time.NewTicker(conf.SummaryInterval.Duration)
+		defer ticker.Stop()
+		summaryChan = ticker.C
+	}
+	idleTicker := time.NewTicker(idleCheckInterval)
+	defer idleTicker.Stop()
+	last := sched.Summary("")
+	idleCount := 0
+	for {
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(os.Stderr, "Received %s, draining the crawl...\n", sig)
+			drained := make(chan crawler.DrainReport, 1)
+			go func() {
+				report, _ := sched.StopDrain(conf.DrainTimeout.Duration)
+				drained <- report
+			}()
+			select {
+			case report := <-drained:
+				printJson(os.Stderr, report)
+			case <-signals:
+				fmt.Fprintln(os.Stderr, "Received the second signal, stopping the crawl...")
+				sched.Stop()
+			}
+			return
+		case <-summaryChan:
+			current := sched.Summary("")
+			fmt.Fprintf(os.Stderr, "%s%s\n", current.String(), current.Diff(last))
+			last = current
+		case <-idleTicker.C:
+			if !sched.Running() && !sched.Paused() {
+				return
+			}
+			//a paused crawl is kept until it's resumed or stopped by the admin api
+			if sched.Paused() || !sched.Idle() {
+				idleCount = 0
+				continue
+			}
+			idleCount++
+			if idleCount >= idleConfirmations {
+				sched.Stop()
+				return
+			}
+		}
+	}
+}
+
+func buildOptions(conf *config) []crawler.SchedOption {
+	options := make([]crawler.SchedOption, 0)
+	if conf.Scope != nil {
+		options = append(options, crawler.WithScope(conf.scopeArgs()))
+	}
+	if conf.Politeness != nil {
+		options = append(options, crawler.WithPoliteness(conf.politenessArgs()))
+	}
+	if conf.Robots {
+		options = append(options, crawler.WithRobots(crawler.RobotsArgs{UserAgent: conf.UserAgent}))
+	}
+	if conf.Sessions != nil {
+		options = append(options, crawler.WithSessions(crawler.SessionArgs{
+			Isolated:   conf.Sessions.Isolated,
+			CookieFile: conf.Sessions.CookieFile,
+		}))
+	}
+	if conf.Login != nil {
+		options = append(options, crawler.WithLogin(conf.loginArgs()))
+	}
+	if conf.HttpCacheDir != "" {
+		options = append(options, crawler.WithHttpCache(crawler.HttpCacheArgs{Dir: conf.HttpCacheDir}))
+	}
+	if conf.RecordFile != "" {
+		options = append(options, crawler.WithRecording(conf.RecordFile))
+	}
+	if conf.ReplayFile != "" {
+		options = append(options, crawler.WithReplay(conf.ReplayFile))
+	}
+	if conf.CheckpointDir != "" {
+		options = append(options, crawler.WithCheckpoint(conf.CheckpointDir, time.Minute))
+	}
+	return options
+}
+
+func buildParsers(conf *config) ([]crawler.ParseResponse, error) {
+	resParsers := make([]crawler.ParseResponse, 0, 2)
+	if *conf.FollowLinks {
+		resParsers = append(resParsers, crawler.NewLinkParser())
+	}
+	rules := conf.ExtractRules
+	if conf.ExtractRulesFile != "" {
+		fileRules, err := crawler.LoadExtractRules(conf.ExtractRulesFile)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, fileRules...)
+	}
+	if len(rules) > 0 {
+		extractor, err := crawler.NewExtractor(rules)
+		if err != nil {
+			return nil, err
+		}
+		resParsers = append(resParsers, extractor)
+	}
+	return resParsers, nil
+}
+
+// openOutput returns the item processor which writes each item as a json line
+func openOutput(conf outputConfig) (crawler.ProcessItem, func(), error) {
+	var writer io.Writer = os.Stdout
+	closeOutput := func() {}
+	if conf.Type == "file" {
+		file, err := os.OpenFile(conf.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		writer = file
+		closeOutput = func() { file.Close() }
+	}
+	var mutex sync.Mutex
+	encoder := json.NewEncoder(writer)
+	output := func(item base.Item) (base.Item, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err := encoder.Encode(item); err != nil {
+			return nil, base.NewCrawlerErrorWithDetail(base.SINK_ERROR, "",
+				base.ErrorDetail{Cause: err, ComponentId: "output"})
+		}
+		return item, nil
+	}
+	return output, closeOutput, nil
+}
+
+func printJson(w io.Writer, value interface{}) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// userAgentTransport sets the user agent of the requests which have none
+type userAgentTransport struct {
+	userAgent string
+	next      http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.userAgent == "" || req.Header.Get("User-Agent") != "" {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
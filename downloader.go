@@ -1,6 +1,7 @@
 package crawler
 
 import (
+	"context"
 	"errors"
 	"gocrawler/base"
 	"gocrawler/middleware"
@@ -13,11 +14,15 @@ var idGenerator middleware.IdGenerator = middleware.NewIdGenerator()
 type PageDownloader interface {
 	Id() uint32
 	Download(req base.Request) (*base.Response, error)
+	//download with a context, the http request is aborted once the context is done
+	DownloadContext(ctx context.Context, req base.Request) (*base.Response, error)
 }
 
 type PageDownloaderPool interface {
 	//get a downloader from pool
 	Take() (PageDownloader, error)
+	//get a downloader from pool, give up when the context is done
+	TakeContext(ctx context.Context) (PageDownloader, error)
 	//return a downloader to pool
 	Return(pd PageDownloader) error
 	//get the total size of the pool
@@ -29,6 +34,8 @@ type PageDownloaderPool interface {
 type myPageDownloader struct {
 	id         uint32
 	httpClient http.Client
+	//nil means the cookie jar of the http client is used
+	sessions CookieSessions
 }
 
 func genDownloaderId() uint32 {
@@ -45,21 +52,49 @@ func NewPageDownloader(client *http.Client) PageDownloader {
 	}
 }
 
+// NewSessionPageDownloader downloads each request with the cookie jar of its session,
+// the pooled downloaders created by it share the cookies
+func NewSessionPageDownloader(client *http.Client, sessions CookieSessions) PageDownloader {
+	downloader := NewPageDownloader(client).(*myPageDownloader)
+	downloader.sessions = sessions
+	return downloader
+}
+
 func (m *myPageDownloader) Id() uint32 {
 	return m.id
 }
 
 func (m *myPageDownloader) Download(req base.Request) (*base.Response, error) {
+	return m.DownloadContext(context.Background(), req)
+}
+
+func (m *myPageDownloader) DownloadContext(ctx context.Context, req base.Request) (*base.Response, error) {
 	// if m.httpClient == nil {
 	// 	errMsg := "http client is not initialized!"
 	// 	return nil, errors.New(errMsg)
 	// }
+	if !req.Valid() {
+		return nil, errors.New("The request is invalid!")
+	}
 
-	res, err := m.httpClient.Do(req.Get())
+	httpClient := &m.httpClient
+	if m.sessions != nil {
+		sessionClient := m.httpClient
+		sessionClient.Jar = m.sessions.Jar(req.Session())
+		httpClient = &sessionClient
+	}
+	//the client adds the cookies of the jar to the headers, they must not stick to the request
+	res, err := httpClient.Do(req.Get().Clone(ctx))
 	if err != nil {
 		return nil, err
 	}
 	httpRes := base.NewResponse(res, req.Depth())
+	httpRes.SetSession(req.Session())
+	attempt := req.Attempt()
+	if attempt == 0 {
+		attempt = 1
+	}
+	httpRes.SetAttempt(attempt)
 	return httpRes, nil
 }
 
@@ -88,7 +123,11 @@ func NewPageDownloaderPool(
 }
 
 func (m *myPageDownloaderPool) Take() (PageDownloader, error) {
-	pdl, err := m.pool.Take()
+	return m.TakeContext(context.Background())
+}
+
+func (m *myPageDownloaderPool) TakeContext(ctx context.Context) (PageDownloader, error) {
+	pdl, err := m.pool.TakeContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,193 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"gocrawler/base"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+)
+
+// ErrRequestDropped is returned by a hook of a downloader middleware to drop the request,
+// a dropped request is neither downloaded nor reported as an error
+var ErrRequestDropped = errors.New("The request is dropped by a downloader middleware!")
+
+// DownloaderMiddleware intercepts the requests and responses of a page downloader
+type DownloaderMiddleware interface {
+	//called before the request is downloaded, the request can be modified in place.
+	//Returning a response skips the download and the following middlewares, returning an
+	//error skips them too and passes the error to ProcessError
+	ProcessRequest(ctx context.Context, req *base.Request) (*base.Response, error)
+	//called with the downloaded response, the returned response replaces it and an error
+	//is passed to ProcessError
+	ProcessResponse(ctx context.Context, req base.Request, res *base.Response) (*base.Response, error)
+	//called when the download or a hook fails, returning a response recovers from the error,
+	//returning an error replaces it
+	ProcessError(ctx context.Context, req base.Request, err error) (*base.Response, error)
+}
+
+type middlewarePageDownloader struct {
+	downloader  PageDownloader
+	middlewares []DownloaderMiddleware
+}
+
+// NewMiddlewarePageDownloader wraps the downloader with the middlewares. ProcessRequest is
+// called in the order of the middlewares, ProcessResponse and ProcessError in the reverse
+// order on the middlewares whose ProcessRequest has been called. The id of the wrapped
+// downloader is kept
+func NewMiddlewarePageDownloader(downloader PageDownloader, middlewares ...DownloaderMiddleware) PageDownloader {
+	return &middlewarePageDownloader{
+		downloader:  downloader,
+		middlewares: middlewares,
+	}
+}
+
+func (m *middlewarePageDownloader) Id() uint32 {
+	return m.downloader.Id()
+}
+
+func (m *middlewarePageDownloader) Download(req base.Request) (*base.Response, error) {
+	return m.DownloadContext(context.Background(), req)
+}
+
+func (m *middlewarePageDownloader) DownloadContext(ctx context.Context, req base.Request) (*base.Response, error) {
+	if !req.Valid() {
+		return nil, errors.New("The request is invalid!")
+	}
+	//the middlewares may change the headers, they must not leak into the original request
+	httpReq := req.Get().Clone(req.Get().Context())
+	attempt := req.Attempt()
+	session := req.Session()
+	req = *base.NewRequest(httpReq, req.Depth())
+	req.SetAttempt(attempt)
+	req.SetSession(session)
+
+	var res *base.Response
+	var err error
+	called := 0
+	for _, middleware := range m.middlewares {
+		called++
+		res, err = middleware.ProcessRequest(ctx, &req)
+		if res != nil || err != nil {
+			break
+		}
+	}
+	if res == nil && err == nil {
+		res, err = m.downloader.DownloadContext(ctx, req)
+	}
+	for i := called - 1; i >= 0; i-- {
+		if errors.Is(err, ErrRequestDropped) {
+			break
+		}
+		if err != nil {
+			res, err = m.middlewares[i].ProcessError(ctx, req, err)
+			continue
+		}
+		if res != nil {
+			res, err = m.middlewares[i].ProcessResponse(ctx, req, res)
+		}
+	}
+	if errors.Is(err, ErrRequestDropped) {
+		if res != nil && res.Get() != nil && res.Get().Body != nil {
+			res.Get().Body.Close()
+		}
+		return nil, nil
+	}
+	if res != nil && res.Session() == "" {
+		res.SetSession(req.Session())
+	}
+	return res, err
+}
+
+// BaseDownloaderMiddleware passes everything through, it can be embedded by the middlewares
+// which only need some of the hooks
+type BaseDownloaderMiddleware struct{}
+
+func (BaseDownloaderMiddleware) ProcessRequest(ctx context.Context, req *base.Request) (*base.Response, error) {
+	return nil, nil
+}
+
+func (BaseDownloaderMiddleware) ProcessResponse(ctx context.Context, req base.Request, res *base.Response) (*base.Response, error) {
+	return res, nil
+}
+
+func (BaseDownloaderMiddleware) ProcessError(ctx context.Context, req base.Request, err error) (*base.Response, error) {
+	return nil, err
+}
+
+type defaultHeadersMiddleware struct {
+	BaseDownloaderMiddleware
+	headers http.Header
+}
+
+// NewDefaultHeadersMiddleware sets the headers which are not set on the requests yet
+func NewDefaultHeadersMiddleware(headers http.Header) DownloaderMiddleware {
+	return &defaultHeadersMiddleware{headers: headers.Clone()}
+}
+
+func (m *defaultHeadersMiddleware) ProcessRequest(ctx context.Context, req *base.Request) (*base.Response, error) {
+	header := req.Get().Header
+	for key, values := range m.headers {
+		if header.Get(key) == "" {
+			header[http.CanonicalHeaderKey(key)] = append([]string{}, values...)
+		}
+	}
+	return nil, nil
+}
+
+type userAgentMiddleware struct {
+	BaseDownloaderMiddleware
+	userAgents []string
+	next       uint64
+}
+
+// NewUserAgentMiddleware sets the user agents in turn on the requests which have none
+func NewUserAgentMiddleware(userAgents ...string) DownloaderMiddleware {
+	if len(userAgents) == 0 {
+		panic(errors.New("The user agent list is empty!"))
+	}
+	return &userAgentMiddleware{userAgents: append([]string{}, userAgents...)}
+}
+
+func (m *userAgentMiddleware) ProcessRequest(ctx context.Context, req *base.Request) (*base.Response, error) {
+	header := req.Get().Header
+	if header.Get("User-Agent") != "" {
+		return nil, nil
+	}
+	index := (atomic.AddUint64(&m.next, 1) - 1) % uint64(len(m.userAgents))
+	header.Set("User-Agent", m.userAgents[index])
+	return nil, nil
+}
+
+type loggingMiddleware struct {
+	logger *log.Logger
+}
+
+// NewLoggingMiddleware logs the requests, the responses and the errors, the standard
+// error is used if logger is nil
+func NewLoggingMiddleware(logger *log.Logger) DownloaderMiddleware {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return &loggingMiddleware{logger: logger}
+}
+
+func (m *loggingMiddleware) ProcessRequest(ctx context.Context, req *base.Request) (*base.Response, error) {
+	m.logger.Printf("Request: %s %s (depth=%d)", req.Get().Method, req.Get().URL, req.Depth())
+	return nil, nil
+}
+
+func (m *loggingMiddleware) ProcessResponse(ctx context.Context, req base.Request, res *base.Response) (*base.Response, error) {
+	if res.Get() != nil {
+		m.logger.Printf("Response: %s %s (status=%d, attempt=%d)",
+			req.Get().Method, req.Get().URL, res.Get().StatusCode, res.Attempt())
+	}
+	return res, nil
+}
+
+func (m *loggingMiddleware) ProcessError(ctx context.Context, req base.Request, err error) (*base.Response, error) {
+	m.logger.Printf("Error: %s %s (%s)", req.Get().Method, req.Get().URL, err)
+	return nil, err
+}
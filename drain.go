@@ -0,0 +1,80 @@
+package crawler
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// the longest time to wait for the stages to notice the stop sign after draining
+var drainGracePeriod = time.Second
+
+// DrainReport tells what happened to the work in each stage when the scheduler is drained.
+// The stages are keyed by DOWNLOADER_CODE, ANALYZER_CODE and ITEMPIPELINE_CODE
+type DrainReport struct {
+	//whether all the stages finished their work before the timeout
+	Drained bool `json:"drained"`
+	//the number of requests downloaded, responses analyzed and items processed while draining
+	Completed map[string]uint64 `json:"completed"`
+	//the number of requests, responses and items given up on stopping, counted by the
+	//stop sign processors of each stage
+	Dropped map[string]uint64 `json:"dropped"`
+	//the number of requests left in request cache, they are saved by the checkpoint
+	Queued uint64 `json:"queued"`
+	//the number of requests refused by AddRequest while draining
+	Rejected uint64 `json:"rejected"`
+}
+
+func (m *myScheduler) StopDrain(timeout time.Duration) (DrainReport, bool) {
+	report := DrainReport{
+		Completed: make(map[string]uint64),
+		Dropped:   make(map[string]uint64),
+	}
+	status := atomic.LoadUint32(&m.running)
+	if status != SCHED_STATUS_RUNNING && status != SCHED_STATUS_PAUSED {
+		return report, false
+	}
+	if !atomic.CompareAndSwapUint32(&m.draining, 0, 1) {
+		return report, false
+	}
+	//the requests in the stages can't be finished while paused
+	m.Resume()
+	downloaded := atomic.LoadUint64(&m.downloaded)
+	analyzed := atomic.LoadUint64(&m.analyzed)
+	processed := atomic.LoadUint64(&m.itemsProcessed)
+
+	report.Drained = m.waitStagesIdle(time.Now().Add(timeout))
+	report.Queued = uint64(m.reqCache.length())
+	if !m.Stop() {
+		return report, false
+	}
+	m.waitStagesIdle(time.Now().Add(drainGracePeriod))
+
+	report.Completed[DOWNLOADER_CODE] = atomic.LoadUint64(&m.downloaded) - downloaded
+	report.Completed[ANALYZER_CODE] = atomic.LoadUint64(&m.analyzed) - analyzed
+	report.Completed[ITEMPIPELINE_CODE] = atomic.LoadUint64(&m.itemsProcessed) - processed
+	for _, stage := range []string{DOWNLOADER_CODE, ANALYZER_CODE, ITEMPIPELINE_CODE} {
+		report.Dropped[stage] = 0
+	}
+	for code, count := range m.stopSign.DealCounts() {
+		stage := parseCode(code)[0]
+		if _, ok := report.Dropped[stage]; ok {
+			report.Dropped[stage] += uint64(count)
+		}
+	}
+	if m.politeness != nil {
+		report.Dropped[DOWNLOADER_CODE] += m.politeness.Dropped()
+	}
+	report.Rejected = atomic.LoadUint64(&m.drainRejected)
+	return report, true
+}
+
+// return false if the deadline passes before the stages are idle
+func (m *myScheduler) waitStagesIdle(deadline time.Time) bool {
+	for !m.stagesIdle() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(scheduleInterval)
+	}
+	return true
+}
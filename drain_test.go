@@ -0,0 +1,112 @@
+package crawler
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startTestDrain drains the scheduler in the background once it's draining
+func startTestDrain(t *testing.T, sched *myScheduler, timeout time.Duration) <-chan DrainReport {
+	reports := make(chan DrainReport, 1)
+	go func() {
+		report, ok := sched.StopDrain(timeout)
+		if !ok {
+			t.Error("StopDrain = false, want true")
+		}
+		reports <- report
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadUint32(&sched.draining) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the scheduler is not draining")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return reports
+}
+
+func TestStopDrain(t *testing.T) {
+	server := newTestHoldServer("/p1", "/p2")
+	defer server.Close()
+	sched := NewScheduler().(*myScheduler)
+	startTestScheduler(t, sched, context.Background(), server.Server, 3)
+	//p0 is crawled, p1 and p2 are being downloaded
+	server.waitHolding(t, 2)
+	reports := startTestDrain(t, sched, 5*time.Second)
+	if err := sched.AddRequest(*newTestRequest(t, server.URL+"/added", 0)); err == nil {
+		t.Error("AddRequest while draining = nil error, want error")
+	}
+	close(server.release)
+	report := <-reports
+
+	if !report.Drained {
+		t.Error("Drained = false, want true")
+	}
+	//p1 and p2 are finished, the p3 and p4 they found are left in the cache
+	want := map[string]uint64{DOWNLOADER_CODE: 2, ANALYZER_CODE: 2, ITEMPIPELINE_CODE: 0}
+	for stage, count := range want {
+		if report.Completed[stage] != count {
+			t.Errorf("Completed[%s] = %d, want %d", stage, report.Completed[stage], count)
+		}
+		if report.Dropped[stage] != 0 {
+			t.Errorf("Dropped[%s] = %d, want 0", stage, report.Dropped[stage])
+		}
+	}
+	if report.Queued != 2 {
+		t.Errorf("Queued = %d, want 2", report.Queued)
+	}
+	if report.Rejected != 1 {
+		t.Errorf("Rejected = %d, want 1", report.Rejected)
+	}
+	if sched.Running() || sched.Summary("").Status != "stopped" {
+		t.Error("the scheduler is not stopped after draining")
+	}
+	if _, ok := sched.StopDrain(time.Second); ok {
+		t.Error("StopDrain of a stopped scheduler = true, want false")
+	}
+}
+
+func TestStopDrainTimeout(t *testing.T) {
+	server := newTestHoldServer("/p1", "/p2")
+	defer server.Close()
+	defer close(server.release)
+	sched := NewScheduler().(*myScheduler)
+	startTestScheduler(t, sched, context.Background(), server.Server, 3)
+	server.waitHolding(t, 2)
+	start := time.Now()
+	report, ok := sched.StopDrain(50 * time.Millisecond)
+	if !ok {
+		t.Fatal("StopDrain = false, want true")
+	}
+	if report.Drained {
+		t.Error("Drained = true with a download held, want false")
+	}
+	if elapsed := time.Since(start); elapsed > drainGracePeriod+time.Second {
+		t.Errorf("StopDrain took %s, want it bounded by the timeout and the grace period", elapsed)
+	}
+	if report.Completed[DOWNLOADER_CODE] != 0 {
+		t.Errorf("Completed[%s] = %d, want 0", DOWNLOADER_CODE, report.Completed[DOWNLOADER_CODE])
+	}
+	if sched.Running() {
+		t.Error("the scheduler is running after the drain timed out")
+	}
+}
+
+func TestStopDrainPaused(t *testing.T) {
+	var hits int64
+	server := newTestSiteServer(&hits)
+	defer server.Close()
+	sched := NewScheduler().(*myScheduler)
+	startTestScheduler(t, sched, context.Background(), server, 3)
+	sched.Pause()
+	//the paused crawl is resumed to drain the requests in the stages
+	report, ok := sched.StopDrain(5 * time.Second)
+	if !ok || !report.Drained {
+		t.Errorf("StopDrain of a paused scheduler = %+v, %v, want drained", report, ok)
+	}
+	if sched.Paused() || sched.Running() {
+		t.Error("the scheduler is not stopped after draining")
+	}
+}
@@ -0,0 +1,279 @@
+package crawler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gocrawler/base"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"gopkg.in/yaml.v3"
+)
+
+// ExtractRule describes how to build items from the pages whose url matches UrlPattern
+type ExtractRule struct {
+	//the regexp which the response url must match, an empty pattern matches every page
+	UrlPattern string `json:"url_pattern" yaml:"url_pattern" toml:"url_pattern"`
+	//each element matched by the selector becomes an item, the whole page is one item if empty
+	ItemSelector string `json:"item_selector" yaml:"item_selector" toml:"item_selector"`
+	//the name of the field which stores the page url, the url is not stored if empty
+	UrlField string      `json:"url_field" yaml:"url_field" toml:"url_field"`
+	Fields   []FieldRule `json:"fields" yaml:"fields" toml:"fields"`
+}
+
+// FieldRule describes how to extract one field of an item
+type FieldRule struct {
+	Name string `json:"name" yaml:"name" toml:"name"`
+	//the css selector relative to the item element, the item element itself if empty
+	Selector string `json:"selector" yaml:"selector" toml:"selector"`
+	//the attribute to read, the text of the element is read if empty
+	Attr string `json:"attr" yaml:"attr" toml:"attr"`
+	//the first submatch of the regexp is kept, or the whole match if it has no group
+	Regex string `json:"regex" yaml:"regex" toml:"regex"`
+	//one of trim, lower, upper, int, float and url, url resolves the value against the page url
+	Transform string `json:"transform" yaml:"transform" toml:"transform"`
+	//keep the values of all matched elements as a list instead of the first one
+	Multiple bool `json:"multiple" yaml:"multiple" toml:"multiple"`
+	//the item is dropped if the field is empty
+	Required bool `json:"required" yaml:"required" toml:"required"`
+}
+
+var fieldTransforms = map[string]bool{
+	"":      true,
+	"trim":  true,
+	"lower": true,
+	"upper": true,
+	"int":   true,
+	"float": true,
+	"url":   true,
+}
+
+// LoadExtractRules reads the rules from a json or yaml file, the format is chosen by the extension
+func LoadExtractRules(path string) ([]ExtractRule, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []ExtractRule
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(content, &rules)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &rules)
+	default:
+		errMsg := fmt.Sprintf("Unsupported rule file format! (path=%s)", path)
+		return nil, errors.New(errMsg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+type compiledField struct {
+	FieldRule
+	selector cascadia.Selector
+	regex    *regexp.Regexp
+}
+
+type compiledRule struct {
+	urlPattern   *regexp.Regexp
+	itemSelector cascadia.Selector
+	urlField     string
+	fields       []compiledField
+}
+
+// NewExtractor returns a response parser which emits the items described by the rules,
+// all rules matching the response url are applied
+func NewExtractor(rules []ExtractRule) (ParseResponse, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for i, rule := range rules {
+		c, err := compileRule(rule)
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid extract rule! Index: %d, %s", i, err)
+			return nil, errors.New(errMsg)
+		}
+		compiled = append(compiled, c)
+	}
+	return func(res base.Response) ([]base.Data, []error) {
+		return extractItems(compiled, res)
+	}, nil
+}
+
+func compileRule(rule ExtractRule) (compiledRule, error) {
+	var result compiledRule
+	var err error
+	if rule.UrlPattern != "" {
+		if result.urlPattern, err = regexp.Compile(rule.UrlPattern); err != nil {
+			return result, err
+		}
+	}
+	if rule.ItemSelector != "" {
+		if result.itemSelector, err = cascadia.Compile(rule.ItemSelector); err != nil {
+			return result, err
+		}
+	}
+	if len(rule.Fields) == 0 {
+		return result, errors.New("The rule has no field!")
+	}
+	result.urlField = rule.UrlField
+	for _, field := range rule.Fields {
+		if field.Name == "" {
+			return result, errors.New("The field name is empty!")
+		}
+		if !fieldTransforms[field.Transform] {
+			return result, errors.New("Unknown transform: " + field.Transform)
+		}
+		c := compiledField{FieldRule: field}
+		if field.Selector != "" {
+			if c.selector, err = cascadia.Compile(field.Selector); err != nil {
+				return result, err
+			}
+		}
+		if field.Regex != "" {
+			if c.regex, err = regexp.Compile(field.Regex); err != nil {
+				return result, err
+			}
+		}
+		result.fields = append(result.fields, c)
+	}
+	return result, nil
+}
+
+func extractItems(rules []compiledRule, res base.Response) ([]base.Data, []error) {
+	httpRes := res.Get()
+	if !isHtml(httpRes) || httpRes.Request == nil || httpRes.Request.URL == nil {
+		return nil, nil
+	}
+	pageUrl := httpRes.Request.URL
+	matched := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.urlPattern == nil || rule.urlPattern.MatchString(pageUrl.String()) {
+			matched = append(matched, rule)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, nil
+	}
+	doc, err := goquery.NewDocumentFromReader(httpRes.Body)
+	if err != nil {
+		return nil, []error{err}
+	}
+	dataList := make([]base.Data, 0)
+	errs := make([]error, 0)
+	for _, rule := range matched {
+		elements := doc.Selection
+		if rule.itemSelector != nil {
+			elements = doc.FindMatcher(rule.itemSelector)
+		}
+		elements.Each(func(_ int, element *goquery.Selection) {
+			item, err := extractItem(rule, element, res)
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			if item != nil {
+				dataList = append(dataList, item)
+			}
+		})
+	}
+	return dataList, errs
+}
+
+// nil is returned if a required field is empty or no field has a value
+func extractItem(rule compiledRule, element *goquery.Selection, res base.Response) (*base.Item, error) {
+	item := make(base.Item)
+	for _, field := range rule.fields {
+		selection := element
+		if field.selector != nil {
+			selection = element.FindMatcher(field.selector)
+		}
+		values := make([]interface{}, 0)
+		for _, node := range selection.Nodes {
+			raw, ok := fieldRaw(field.FieldRule, goquery.NewDocumentFromNode(node).Selection)
+			if !ok {
+				continue
+			}
+			value, ok, err := transformField(field, raw, res)
+			if err != nil {
+				errMsg := fmt.Sprintf("Failed to extract field %s! %s", field.Name, err)
+				return nil, errors.New(errMsg)
+			}
+			if !ok {
+				continue
+			}
+			values = append(values, value)
+			if !field.Multiple {
+				break
+			}
+		}
+		if len(values) == 0 {
+			if field.Required {
+				return nil, nil
+			}
+			continue
+		}
+		if field.Multiple {
+			item[field.Name] = values
+		} else {
+			item[field.Name] = values[0]
+		}
+	}
+	if len(item) == 0 {
+		return nil, nil
+	}
+	if rule.urlField != "" {
+		item[rule.urlField] = res.Get().Request.URL.String()
+	}
+	return &item, nil
+}
+
+// empty values are skipped
+func fieldRaw(field FieldRule, selection *goquery.Selection) (string, bool) {
+	if field.Attr == "" {
+		value := strings.TrimSpace(selection.Text())
+		return value, value != ""
+	}
+	value, ok := selection.Attr(field.Attr)
+	value = strings.TrimSpace(value)
+	return value, ok && value != ""
+}
+
+// the value is skipped if it doesn't match the regexp
+func transformField(field compiledField, raw string, res base.Response) (interface{}, bool, error) {
+	if field.regex != nil {
+		match := field.regex.FindStringSubmatch(raw)
+		if match == nil {
+			return nil, false, nil
+		}
+		raw = match[0]
+		if len(match) > 1 {
+			raw = match[1]
+		}
+	}
+	switch field.Transform {
+	case "lower":
+		return strings.ToLower(raw), true, nil
+	case "upper":
+		return strings.ToUpper(raw), true, nil
+	case "int":
+		value, err := strconv.ParseInt(strings.ReplaceAll(raw, ",", ""), 10, 64)
+		return value, err == nil, err
+	case "float":
+		value, err := strconv.ParseFloat(strings.ReplaceAll(raw, ",", ""), 64)
+		return value, err == nil, err
+	case "url":
+		u, err := res.Get().Request.URL.Parse(raw)
+		if err != nil {
+			return nil, false, err
+		}
+		return u.String(), true, nil
+	}
+	return strings.TrimSpace(raw), true, nil
+}
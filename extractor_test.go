@@ -0,0 +1,207 @@
+package crawler
+
+import (
+	"fmt"
+	"gocrawler/base"
+	"testing"
+)
+
+const testProductPage = `<html><body>
+<div class="product">
+	<h2> Blue Widget </h2>
+	<span class="price">$1,234.50</span>
+	<span class="stock">In stock: 12</span>
+	<a href="/p/1">more</a>
+	<span class="tag">New</span><span class="tag">Sale</span>
+</div>
+<div class="product">
+	<h2>Red Widget</h2>
+	<a href="http://other.com/p/2">more</a>
+</div>
+</body></html>`
+
+func TestExtractorFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		field FieldRule
+		//the field value of each item, nil means the field is absent
+		want []interface{}
+	}{
+		{
+			name:  "text",
+			field: FieldRule{Name: "v", Selector: "h2"},
+			want:  []interface{}{"Blue Widget", "Red Widget"},
+		},
+		{
+			name:  "lower",
+			field: FieldRule{Name: "v", Selector: "h2", Transform: "lower"},
+			want:  []interface{}{"blue widget", "red widget"},
+		},
+		{
+			name:  "upper",
+			field: FieldRule{Name: "v", Selector: "h2", Transform: "upper"},
+			want:  []interface{}{"BLUE WIDGET", "RED WIDGET"},
+		},
+		{
+			name:  "float with regex",
+			field: FieldRule{Name: "v", Selector: ".price", Regex: `\$([\d,.]+)`, Transform: "float"},
+			want:  []interface{}{1234.5, nil},
+		},
+		{
+			name:  "int with whole match",
+			field: FieldRule{Name: "v", Selector: ".stock", Regex: `\d+`, Transform: "int"},
+			want:  []interface{}{int64(12), nil},
+		},
+		{
+			name:  "regex not matched",
+			field: FieldRule{Name: "v", Selector: "h2", Regex: `^Red`},
+			want:  []interface{}{nil, "Red"},
+		},
+		{
+			name:  "attr resolved as url",
+			field: FieldRule{Name: "v", Selector: "a", Attr: "href", Transform: "url"},
+			want:  []interface{}{"http://example.com/p/1", "http://other.com/p/2"},
+		},
+		{
+			name:  "missing attr",
+			field: FieldRule{Name: "v", Selector: "a", Attr: "title"},
+			want:  []interface{}{nil, nil},
+		},
+		{
+			name:  "multiple",
+			field: FieldRule{Name: "v", Selector: ".tag", Multiple: true},
+			want:  []interface{}{[]interface{}{"New", "Sale"}, nil},
+		},
+	}
+	for _, test := range tests {
+		rules := []ExtractRule{{
+			ItemSelector: ".product",
+			Fields:       []FieldRule{{Name: "title", Selector: "h2"}, test.field},
+		}}
+		extract, err := NewExtractor(rules)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		dataList, errs := extract(newTestResponse(t, "http://example.com/list", nil, testProductPage))
+		if len(errs) > 0 {
+			t.Errorf("%s: unexpected errors: %v", test.name, errs)
+			continue
+		}
+		if len(dataList) != len(test.want) {
+			t.Errorf("%s: %d items, want %d", test.name, len(dataList), len(test.want))
+			continue
+		}
+		for i, data := range dataList {
+			item := *data.(*base.Item)
+			got, ok := item[test.field.Name]
+			if test.want[i] == nil {
+				if ok {
+					t.Errorf("%s: item %d field = %v, want absent", test.name, i, got)
+				}
+				continue
+			}
+			if fmt.Sprintf("%#v", got) != fmt.Sprintf("%#v", test.want[i]) {
+				t.Errorf("%s: item %d field = %#v, want %#v", test.name, i, got, test.want[i])
+			}
+		}
+	}
+}
+
+func TestExtractorRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   ExtractRule
+		rawUrl string
+		//the titles of the items
+		want []string
+	}{
+		{
+			name:   "url pattern matched",
+			rule:   ExtractRule{UrlPattern: `/list$`, ItemSelector: ".product", Fields: []FieldRule{{Name: "title", Selector: "h2"}}},
+			rawUrl: "http://example.com/list",
+			want:   []string{"Blue Widget", "Red Widget"},
+		},
+		{
+			name:   "url pattern not matched",
+			rule:   ExtractRule{UrlPattern: `/detail/`, ItemSelector: ".product", Fields: []FieldRule{{Name: "title", Selector: "h2"}}},
+			rawUrl: "http://example.com/list",
+			want:   []string{},
+		},
+		{
+			name:   "whole page",
+			rule:   ExtractRule{Fields: []FieldRule{{Name: "title", Selector: "h2"}}},
+			rawUrl: "http://example.com/list",
+			want:   []string{"Blue Widget"},
+		},
+		{
+			name: "required field",
+			rule: ExtractRule{ItemSelector: ".product", Fields: []FieldRule{
+				{Name: "title", Selector: "h2"}, {Name: "price", Selector: ".price", Required: true}}},
+			rawUrl: "http://example.com/list",
+			want:   []string{"Blue Widget"},
+		},
+	}
+	for _, test := range tests {
+		extract, err := NewExtractor([]ExtractRule{test.rule})
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		dataList, errs := extract(newTestResponse(t, test.rawUrl, nil, testProductPage))
+		if len(errs) > 0 {
+			t.Errorf("%s: unexpected errors: %v", test.name, errs)
+			continue
+		}
+		got := make([]string, 0, len(dataList))
+		for _, data := range dataList {
+			got = append(got, fmt.Sprint((*data.(*base.Item))["title"]))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: titles = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestExtractorUrlField(t *testing.T) {
+	extract, err := NewExtractor([]ExtractRule{{UrlField: "url", Fields: []FieldRule{{Name: "title", Selector: "h2"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataList, _ := extract(newTestResponse(t, "http://example.com/list", nil, testProductPage))
+	if len(dataList) != 1 {
+		t.Fatalf("%d items, want 1", len(dataList))
+	}
+	if got := (*dataList[0].(*base.Item))["url"]; got != "http://example.com/list" {
+		t.Errorf("url field = %v, want http://example.com/list", got)
+	}
+}
+
+func TestExtractorTransformError(t *testing.T) {
+	extract, err := NewExtractor([]ExtractRule{{Fields: []FieldRule{{Name: "n", Selector: "h2", Transform: "int"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataList, errs := extract(newTestResponse(t, "http://example.com/list", nil, testProductPage))
+	if len(dataList) != 0 || len(errs) != 1 {
+		t.Errorf("got %d items and %d errors, want 0 items and 1 error", len(dataList), len(errs))
+	}
+}
+
+func TestNewExtractorInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ExtractRule
+	}{
+		{"no field", ExtractRule{}},
+		{"empty field name", ExtractRule{Fields: []FieldRule{{Selector: "h2"}}}},
+		{"unknown transform", ExtractRule{Fields: []FieldRule{{Name: "v", Transform: "reverse"}}}},
+		{"invalid url pattern", ExtractRule{UrlPattern: "(", Fields: []FieldRule{{Name: "v"}}}},
+		{"invalid item selector", ExtractRule{ItemSelector: "[", Fields: []FieldRule{{Name: "v"}}}},
+		{"invalid field selector", ExtractRule{Fields: []FieldRule{{Name: "v", Selector: "["}}}},
+		{"invalid field regex", ExtractRule{Fields: []FieldRule{{Name: "v", Regex: "("}}}},
+	}
+	for _, test := range tests {
+		if _, err := NewExtractor([]ExtractRule{test.rule}); err == nil {
+			t.Errorf("%s: NewExtractor error = nil, want error", test.name)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+module gocrawler
+
+go 1.21
+
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/PuerkitoBio/goquery v1.9.3
+	github.com/andybalholm/cascadia v1.3.3
+	golang.org/x/net v0.33.0
+	gopkg.in/yaml.v3 v3.0.1
+)
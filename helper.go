@@ -0,0 +1,56 @@
+package crawler
+
+import (
+	"fmt"
+	"gocrawler/base"
+	"strings"
+)
+
+// codes are used to identify the component which deals the stop sign or reports the error
+const (
+	DOWNLOADER_CODE   = "downloader"
+	ANALYZER_CODE     = "analyzer"
+	ITEMPIPELINE_CODE = "item_pipeline"
+	SCHEDULER_CODE    = "scheduler"
+)
+
+func generateCode(prefix string, id uint32) string {
+	return fmt.Sprintf("%s-%d", prefix, id)
+}
+
+func parseCode(code string) []string {
+	result := make([]string, 2)
+	var codePrefix, id string
+	index := strings.Index(code, "-")
+	if index > 0 {
+		codePrefix = code[:index]
+		id = code[index+1:]
+	} else {
+		codePrefix = code
+	}
+	result[0] = codePrefix
+	result[1] = id
+	return result
+}
+
+func errorTypeOfCode(code string) base.ErrorType {
+	switch parseCode(code)[0] {
+	case DOWNLOADER_CODE:
+		return base.DOWNLOADER_ERROR
+	case ANALYZER_CODE:
+		return base.ANALYZER_ERROR
+	case ITEMPIPELINE_CODE:
+		return base.ITEM_PROCESSOR_ERROR
+	case SCHEDULER_CODE:
+		return base.SCHEDULER_ERROR
+	}
+	return ""
+}
+
+func genAnalyzer() Analyzer {
+	analyzer, err := NewAnalyzer()
+	if err != nil {
+		panic(err)
+	}
+	return analyzer
+}
@@ -0,0 +1,408 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gocrawler/base"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// the max size of a stored body if HttpCacheArgs.MaxBodySize is 0
+var defaultCacheBodySize int64 = 10 * 1024 * 1024
+
+type HttpCacheArgs struct {
+	//the directory the responses are stored in
+	Dir string
+	//the max size of a stored body, the larger responses are not stored. 0 means 10MB
+	MaxBodySize int64
+}
+
+// cacheEntry is the stored form of a response, it's the first line of a cache file and
+// the body follows it
+type cacheEntry struct {
+	Url        string      `json:"url"`
+	Status     string      `json:"status"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	//the values of the request headers named by the Vary header
+	VaryHeader   http.Header `json:"vary_header,omitempty"`
+	RequestTime  time.Time   `json:"request_time"`
+	ResponseTime time.Time   `json:"response_time"`
+}
+
+// parseCacheControl returns the directives of the Cache-Control header with lower case names
+func parseCacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, arg, _ := strings.Cut(part, "=")
+			directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+		}
+	}
+	return directives
+}
+
+// lifetime is how long the response is fresh after it's received, 0 means it has to be
+// revalidated before it's used
+func (e *cacheEntry) lifetime() time.Duration {
+	directives := parseCacheControl(e.Header)
+	if _, ok := directives["no-cache"]; ok {
+		return 0
+	}
+	if maxAge, ok := directives["max-age"]; ok {
+		seconds, err := strconv.ParseInt(maxAge, 10, 64)
+		if err != nil || seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if value := e.Header.Get("Expires"); value != "" {
+		expires, err := http.ParseTime(value)
+		if err != nil {
+			return 0
+		}
+		date, err := http.ParseTime(e.Header.Get("Date"))
+		if err != nil {
+			date = e.ResponseTime
+		}
+		return expires.Sub(date)
+	}
+	return 0
+}
+
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	age := now.Sub(e.ResponseTime)
+	if seconds, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		age += time.Duration(seconds) * time.Second
+	}
+	return age
+}
+
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return e.age(now) < e.lifetime()
+}
+
+// matches tells whether the entry can be used for the request by the Vary header
+func (e *cacheEntry) matches(req *http.Request) bool {
+	for name, values := range e.VaryHeader {
+		if strings.Join(req.Header.Values(name), ",") != strings.Join(values, ",") {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *cacheEntry) response(req *http.Request, body []byte) *http.Response {
+	return &http.Response{
+		Status:        e.Status,
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// httpCache stores the responses in files named by the hash of the normalized url and
+// the session, it's shared by the downloaders
+type httpCache struct {
+	args        HttpCacheArgs
+	hits        uint64
+	revalidated uint64
+	misses      uint64
+	stored      uint64
+	failed      uint64
+	//called with the errors of storing the responses, they don't fail the downloads.
+	//nil means they are only counted
+	reportError func(err error)
+}
+
+func newHttpCache(args HttpCacheArgs) (*httpCache, error) {
+	if args.Dir == "" {
+		return nil, errors.New("The http cache directory is empty!")
+	}
+	if args.MaxBodySize <= 0 {
+		args.MaxBodySize = defaultCacheBodySize
+	}
+	if err := os.MkdirAll(args.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return &httpCache{args: args}, nil
+}
+
+func (c *httpCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.args.Dir, name[:2], name)
+}
+
+// load returns nil if the key is not stored
+func (c *httpCache) load(key string) (*cacheEntry, []byte, error) {
+	file, err := os.Open(c.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, nil, err
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(line, entry); err != nil {
+		return nil, nil, err
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return entry, body, nil
+}
+
+func (c *httpCache) store(key string, entry *cacheEntry, body []byte) error {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		_, err := w.Write(body)
+		return err
+	})
+	if err == nil {
+		atomic.AddUint64(&c.stored, 1)
+	}
+	return err
+}
+
+func (c *httpCache) remove(key string) {
+	os.Remove(c.path(key))
+}
+
+// save stores the entry, a failure is counted and reported
+func (c *httpCache) save(key string, entry *cacheEntry, body []byte) {
+	err := c.store(key, entry, body)
+	if err == nil {
+		return
+	}
+	atomic.AddUint64(&c.failed, 1)
+	if c.reportError != nil {
+		errMsg := fmt.Sprintf("Failed to store the response in http cache: %s", err)
+		c.reportError(base.NewCrawlerErrorWithDetail(base.DOWNLOADER_ERROR, errMsg, base.ErrorDetail{
+			Cause: err,
+			Url:   entry.Url,
+		}))
+	}
+}
+
+func (c *httpCache) summary() HttpCacheSummary {
+	return HttpCacheSummary{
+		Hits:        atomic.LoadUint64(&c.hits),
+		Revalidated: atomic.LoadUint64(&c.revalidated),
+		Misses:      atomic.LoadUint64(&c.misses),
+		Stored:      atomic.LoadUint64(&c.stored),
+		Failed:      atomic.LoadUint64(&c.failed),
+	}
+}
+
+// cacheKey separates the responses of the cookie sessions, so a page got with the cookies
+// of one session is never served to another
+func cacheKey(req *base.Request) string {
+	if req.Session() == "" {
+		return base.RequestKey(req)
+	}
+	return base.RequestKey(req) + "\n" + req.Session()
+}
+
+type cachePageDownloader struct {
+	downloader PageDownloader
+	cache      *httpCache
+}
+
+// NewCachePageDownloader serves the GET requests from the responses stored in the directory
+// while they are fresh by Cache-Control and Expires, and revalidates them with If-None-Match
+// and If-Modified-Since afterwards. The responses served from the cache are flagged as
+// cached and unchanged. The responses are stored per cookie session, the requests with
+// their own Cookie or Authorization header are never cached. The errors of storing the
+// responses are counted in the summary. The id of the wrapped downloader is kept
+func NewCachePageDownloader(downloader PageDownloader, args HttpCacheArgs) (PageDownloader, error) {
+	cache, err := newHttpCache(args)
+	if err != nil {
+		return nil, err
+	}
+	return newCachePageDownloader(downloader, cache), nil
+}
+
+func newCachePageDownloader(downloader PageDownloader, cache *httpCache) PageDownloader {
+	return &cachePageDownloader{
+		downloader: downloader,
+		cache:      cache,
+	}
+}
+
+func (m *cachePageDownloader) Id() uint32 {
+	return m.downloader.Id()
+}
+
+func (m *cachePageDownloader) Download(req base.Request) (*base.Response, error) {
+	return m.DownloadContext(context.Background(), req)
+}
+
+func (m *cachePageDownloader) DownloadContext(ctx context.Context, req base.Request) (*base.Response, error) {
+	if !req.Valid() {
+		return nil, errors.New("The request is invalid!")
+	}
+	httpReq := req.Get()
+	reqDirectives := parseCacheControl(httpReq.Header)
+	if _, noStore := reqDirectives["no-store"]; httpReq.Method != http.MethodGet || noStore ||
+		httpReq.Header.Get("Cookie") != "" || httpReq.Header.Get("Authorization") != "" {
+		return m.downloader.DownloadContext(ctx, req)
+	}
+	key := cacheKey(&req)
+	entry, body, err := m.cache.load(key)
+	if err != nil || (entry != nil && !entry.matches(httpReq)) {
+		//a broken entry is downloaded again
+		entry = nil
+	}
+	_, noCache := reqDirectives["no-cache"]
+	if entry != nil && !noCache && entry.fresh(time.Now()) {
+		atomic.AddUint64(&m.cache.hits, 1)
+		return m.cachedResponse(req, entry, body), nil
+	}
+
+	condReq := req
+	if entry != nil {
+		condHttpReq := httpReq.Clone(httpReq.Context())
+		if etag := entry.Header.Get("ETag"); etag != "" && condHttpReq.Header.Get("If-None-Match") == "" {
+			condHttpReq.Header.Set("If-None-Match", etag)
+		}
+		if modified := entry.Header.Get("Last-Modified"); modified != "" &&
+			condHttpReq.Header.Get("If-Modified-Since") == "" {
+			condHttpReq.Header.Set("If-Modified-Since", modified)
+		}
+		condReq = *base.NewRequest(condHttpReq, req.Depth())
+		condReq.SetAttempt(req.Attempt())
+		condReq.SetSession(req.Session())
+	}
+	requestTime := time.Now()
+	res, err := m.downloader.DownloadContext(ctx, condReq)
+	if err != nil || res == nil || res.Get() == nil {
+		return res, err
+	}
+	httpRes := res.Get()
+	if httpRes.StatusCode == http.StatusNotModified && entry != nil {
+		discardBody(httpRes)
+		for name, values := range httpRes.Header {
+			entry.Header[name] = values
+		}
+		entry.RequestTime = requestTime
+		entry.ResponseTime = time.Now()
+		m.cache.save(key, entry, body)
+		atomic.AddUint64(&m.cache.revalidated, 1)
+		cached := m.cachedResponse(req, entry, body)
+		cached.SetAttempt(res.Attempt())
+		return cached, nil
+	}
+	atomic.AddUint64(&m.cache.misses, 1)
+	return m.storeResponse(key, entry, res, requestTime)
+}
+
+func (m *cachePageDownloader) cachedResponse(req base.Request, entry *cacheEntry, body []byte) *base.Response {
+	res := base.NewResponse(entry.response(req.Get(), body), req.Depth())
+	attempt := req.Attempt()
+	if attempt == 0 {
+		attempt = 1
+	}
+	res.SetAttempt(attempt)
+	res.SetSession(req.Session())
+	res.SetCached(true)
+	return res
+}
+
+// storeResponse stores the response if it's allowed and useful, a stored body is read
+// into memory and put back
+func (m *cachePageDownloader) storeResponse(key string, old *cacheEntry, res *base.Response, requestTime time.Time) (*base.Response, error) {
+	httpRes := res.Get()
+	entry := &cacheEntry{
+		Url:          httpRes.Request.URL.String(),
+		Status:       httpRes.Status,
+		StatusCode:   httpRes.StatusCode,
+		Header:       httpRes.Header.Clone(),
+		RequestTime:  requestTime,
+		ResponseTime: time.Now(),
+	}
+	if old != nil && entry.Header.Get("ETag") != "" && entry.Header.Get("ETag") == old.Header.Get("ETag") {
+		res.SetUnchanged(true)
+	}
+	_, noStore := parseCacheControl(httpRes.Header)["no-store"]
+	vary := httpRes.Header.Values("Vary")
+	if noStore || httpRes.StatusCode != http.StatusOK || httpRes.Body == nil {
+		if noStore && old != nil {
+			m.cache.remove(key)
+		}
+		return res, nil
+	}
+	if entry.lifetime() <= 0 && entry.Header.Get("ETag") == "" && entry.Header.Get("Last-Modified") == "" {
+		return res, nil
+	}
+	for _, value := range vary {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return res, nil
+			}
+			if name == "" {
+				continue
+			}
+			if entry.VaryHeader == nil {
+				entry.VaryHeader = make(http.Header)
+			}
+			entry.VaryHeader[http.CanonicalHeaderKey(name)] = httpRes.Request.Header.Values(name)
+		}
+	}
+	body, err := io.ReadAll(io.LimitReader(httpRes.Body, m.cache.args.MaxBodySize+1))
+	if err != nil {
+		httpRes.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > m.cache.args.MaxBodySize {
+		httpRes.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), httpRes.Body), httpRes.Body}
+		return res, nil
+	}
+	httpRes.Body.Close()
+	httpRes.Body = io.NopCloser(bytes.NewReader(body))
+	m.cache.save(key, entry, body)
+	return res, nil
+}
@@ -0,0 +1,104 @@
+package crawler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCacheEntryLifetime(t *testing.T) {
+	responseTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	date := responseTime.Add(-time.Minute).Format(http.TimeFormat)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"no header", http.Header{}, 0},
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=60"}}, time.Minute},
+		{"quoted max-age", http.Header{"Cache-Control": {`Max-Age="30"`}}, 30 * time.Second},
+		{"invalid max-age", http.Header{"Cache-Control": {"max-age=soon"}}, 0},
+		{"negative max-age", http.Header{"Cache-Control": {"max-age=-1"}}, 0},
+		{"no-cache", http.Header{"Cache-Control": {"max-age=60", "no-cache"}}, 0},
+		{"max-age over expires", http.Header{
+			"Cache-Control": {"max-age=10"},
+			"Expires":       {responseTime.Add(time.Hour).Format(http.TimeFormat)},
+		}, 10 * time.Second},
+		{"expires by date", http.Header{
+			"Date":    {date},
+			"Expires": {responseTime.Add(time.Hour).Format(http.TimeFormat)},
+		}, time.Hour + time.Minute},
+		{"expires by response time", http.Header{
+			"Expires": {responseTime.Add(time.Hour).Format(http.TimeFormat)},
+		}, time.Hour},
+		{"expired", http.Header{
+			"Date":    {date},
+			"Expires": {responseTime.Add(-time.Hour).Format(http.TimeFormat)},
+		}, -time.Hour + time.Minute},
+		{"invalid expires", http.Header{"Expires": {"0"}}, 0},
+	}
+	for _, test := range tests {
+		entry := &cacheEntry{Header: test.header, ResponseTime: responseTime}
+		if got := entry.lifetime(); got != test.want {
+			t.Errorf("%s: lifetime = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCacheEntryFresh(t *testing.T) {
+	responseTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		header  http.Header
+		elapsed time.Duration
+		want    bool
+	}{
+		{"within max-age", http.Header{"Cache-Control": {"max-age=60"}}, 59 * time.Second, true},
+		{"max-age passed", http.Header{"Cache-Control": {"max-age=60"}}, 60 * time.Second, false},
+		{"age counted", http.Header{"Cache-Control": {"max-age=60"}, "Age": {"30"}}, 40 * time.Second, false},
+		{"age within max-age", http.Header{"Cache-Control": {"max-age=60"}, "Age": {"30"}}, 20 * time.Second, true},
+		{"invalid age", http.Header{"Cache-Control": {"max-age=60"}, "Age": {"x"}}, 20 * time.Second, true},
+		{"no lifetime", http.Header{}, 0, false},
+	}
+	for _, test := range tests {
+		entry := &cacheEntry{Header: test.header, ResponseTime: responseTime}
+		if got := entry.fresh(responseTime.Add(test.elapsed)); got != test.want {
+			t.Errorf("%s: fresh = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCacheEntryMatches(t *testing.T) {
+	tests := []struct {
+		name       string
+		varyHeader http.Header
+		reqHeader  http.Header
+		want       bool
+	}{
+		{"no vary", nil, http.Header{"Accept-Language": {"en"}}, true},
+		{"same value", http.Header{"Accept-Language": {"en"}}, http.Header{"Accept-Language": {"en"}}, true},
+		{"other value", http.Header{"Accept-Language": {"en"}}, http.Header{"Accept-Language": {"fr"}}, false},
+		{"missing value", http.Header{"Accept-Language": {"en"}}, http.Header{}, false},
+		{"both missing", http.Header{"Accept-Language": nil}, http.Header{}, true},
+		{"multiple values", http.Header{"Accept": {"a", "b"}}, http.Header{"Accept": {"a", "b"}}, true},
+	}
+	for _, test := range tests {
+		entry := &cacheEntry{VaryHeader: test.varyHeader}
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		req.Header = test.reqHeader
+		if got := entry.matches(req); got != test.want {
+			t.Errorf("%s: matches = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	req := newTestRequest(t, "http://Example.com/a#top", 0)
+	if got := cacheKey(req); got != "http://example.com/a" {
+		t.Errorf("cacheKey without session = %q, want %q", got, "http://example.com/a")
+	}
+	req.SetSession("user1")
+	if got := cacheKey(req); got != "http://example.com/a\nuser1" {
+		t.Errorf("cacheKey with session = %q, want %q", got, "http://example.com/a\nuser1")
+	}
+}
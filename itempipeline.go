@@ -1,6 +1,7 @@
 package crawler
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"gocrawler/base"
@@ -10,6 +11,8 @@ import (
 type ItemPipeline interface {
 	//send item
 	Send(item base.Item) []error
+	//send item with a context, the remaining processors are skipped once the context is done
+	SendContext(ctx context.Context, item base.Item) []error
 	//FailFast means when failed to process one item, whether or not ignore the coming items
 	FailFast() bool
 	//set failfast
@@ -58,6 +61,10 @@ func NewItemPipeline(itemProcessors []ProcessItem) ItemPipeline {
 
 //Whether there is a need to set failFast? Normally, the ItemProcess func will return nil,err if err is not nil
 func (m *myItemPipeline) Send(item base.Item) []error {
+	return m.SendContext(context.Background(), item)
+}
+
+func (m *myItemPipeline) SendContext(ctx context.Context, item base.Item) []error {
 	atomic.AddUint64(&m.sent, 1)
 	//defer atomic.AddUint64(&addr, ^uint64(0))
 	errs := make([]error, 0)
@@ -70,6 +77,9 @@ func (m *myItemPipeline) Send(item base.Item) []error {
 	currentItem := item
 	//var processedItem base.Item
 	for _, processor := range m.itemProcessors {
+		if err := ctx.Err(); err != nil {
+			return append(errs, err)
+		}
 		atomic.AddUint64(&m.processing, 1)
 		//defer func is executed after this function end, aka after return in this function. reasonable?
 		defer atomic.AddUint64(&m.processing, ^uint64(0))
@@ -109,6 +119,13 @@ func (m *myItemPipeline) Count() (uint64, uint64, uint64) {
 	return sent, accepted, processed
 }
 
+// restoreCount sets the counters saved by a checkpoint
+func (m *myItemPipeline) restoreCount(sent uint64, accepted uint64, processed uint64) {
+	atomic.StoreUint64(&m.sent, sent)
+	atomic.StoreUint64(&m.accepted, accepted)
+	atomic.StoreUint64(&m.processed, processed)
+}
+
 func (m *myItemPipeline) ProcessingNumber() uint64 {
 	processing := atomic.LoadUint64(&m.processing)
 	return processing
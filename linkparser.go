@@ -0,0 +1,148 @@
+package crawler
+
+import (
+	"errors"
+	"gocrawler/base"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// the tags which link to other pages and the attribute holding the link
+var linkAttrs = map[string]string{
+	"a":      "href",
+	"area":   "href",
+	"link":   "href",
+	"frame":  "src",
+	"iframe": "src",
+}
+
+// NewLinkParser returns a response parser which extracts the links of html pages as new
+// requests. The links are resolved against <base href> and the response url, the links
+// marked with rel="nofollow" are skipped, and so are all links of a page marked nofollow by
+// <meta name="robots"> or the X-Robots-Tag header
+func NewLinkParser() ParseResponse {
+	return parseLinks
+}
+
+func parseLinks(res base.Response) ([]base.Data, []error) {
+	httpRes := res.Get()
+	if !isHtml(httpRes) {
+		return nil, nil
+	}
+	if httpRes.Request == nil || httpRes.Request.URL == nil {
+		return nil, []error{errors.New("The request of the response is unknown!")}
+	}
+	if hasNofollow(httpRes.Header.Get("X-Robots-Tag")) {
+		return nil, nil
+	}
+	links, baseHref, nofollow, err := tokenizeLinks(httpRes.Body)
+	if err != nil {
+		return nil, []error{err}
+	}
+	if nofollow {
+		return nil, nil
+	}
+	baseUrl := httpRes.Request.URL
+	if baseHref != "" {
+		if u, err := baseUrl.Parse(baseHref); err == nil {
+			baseUrl = u
+		}
+	}
+	dataList := make([]base.Data, 0, len(links))
+	seen := make(map[string]bool)
+	for _, link := range links {
+		u, err := baseUrl.Parse(link)
+		if err != nil {
+			continue
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			continue
+		}
+		u.Fragment = ""
+		u.RawFragment = ""
+		link := u.String()
+		if seen[link] {
+			continue
+		}
+		seen[link] = true
+		httpReq, err := http.NewRequest("GET", link, nil)
+		if err != nil {
+			continue
+		}
+		//the depth is increased by the analyzer
+		dataList = append(dataList, base.NewRequest(httpReq, res.Depth()))
+	}
+	return dataList, nil
+}
+
+// a response without content type is sniffed as html
+func isHtml(res *http.Response) bool {
+	contentType := strings.ToLower(res.Header.Get("Content-Type"))
+	return contentType == "" || strings.Contains(contentType, "html")
+}
+
+func hasNofollow(directives string) bool {
+	for _, directive := range strings.Split(strings.ToLower(directives), ",") {
+		directive = strings.TrimSpace(directive)
+		if directive == "nofollow" || directive == "none" {
+			return true
+		}
+	}
+	return false
+}
+
+func hasRelNofollow(rel string) bool {
+	for _, token := range strings.Fields(strings.ToLower(rel)) {
+		if token == "nofollow" {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenizeLinks returns the raw links, the first <base href> and whether the page is nofollow
+func tokenizeLinks(r io.Reader) ([]string, string, bool, error) {
+	links := make([]string, 0)
+	baseHref := ""
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return nil, "", false, err
+			}
+			return links, baseHref, false, nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			attrs := make(map[string]string, len(token.Attr))
+			for _, attr := range token.Attr {
+				attrs[strings.ToLower(attr.Key)] = attr.Val
+			}
+			switch token.Data {
+			case "base":
+				if baseHref == "" {
+					baseHref = strings.TrimSpace(attrs["href"])
+				}
+				continue
+			case "meta":
+				if strings.EqualFold(attrs["name"], "robots") && hasNofollow(attrs["content"]) {
+					return nil, "", true, nil
+				}
+				continue
+			}
+			attrName, ok := linkAttrs[token.Data]
+			if !ok {
+				continue
+			}
+			if hasRelNofollow(attrs["rel"]) {
+				continue
+			}
+			if link := strings.TrimSpace(attrs[attrName]); link != "" {
+				links = append(links, link)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package crawler
+
+import (
+	"gocrawler/base"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newTestResponse builds the response of a GET request to rawUrl
+func newTestResponse(t *testing.T, rawUrl string, header http.Header, body string) base.Response {
+	httpReq, err := http.NewRequest("GET", rawUrl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header == nil {
+		header = http.Header{"Content-Type": {"text/html; charset=utf-8"}}
+	}
+	httpRes := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    httpReq,
+	}
+	return *base.NewResponse(httpRes, 1)
+}
+
+func TestParseLinks(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		body   string
+		want   []string
+	}{
+		{
+			name: "relative and absolute",
+			body: `<a href="b">b</a><a href="/c?x=1#frag">c</a><a href="https://other.com/d">d</a>`,
+			want: []string{"http://example.com/dir/b", "http://example.com/c?x=1", "https://other.com/d"},
+		},
+		{
+			name: "other tags",
+			body: `<area href="/area"><link rel="next" href="/link"><iframe src="/iframe"></iframe><img src="/img.png">`,
+			want: []string{"http://example.com/area", "http://example.com/link", "http://example.com/iframe"},
+		},
+		{
+			name: "base href",
+			body: `<head><base href="http://base.com/root/"><base href="/ignored/"></head><a href="x">x</a>`,
+			want: []string{"http://base.com/root/x"},
+		},
+		{
+			name: "duplicates and fragments",
+			body: `<a href="/a">1</a><a href="/a#top">2</a><a HREF=" /a ">3</a>`,
+			want: []string{"http://example.com/a"},
+		},
+		{
+			name: "unsupported schemes",
+			body: `<a href="mailto:a@b.com">m</a><a href="javascript:void(0)">j</a><a href="ftp://h/f">f</a><a href="">e</a>`,
+			want: []string{},
+		},
+		{
+			name: "rel nofollow",
+			body: `<a href="/skip" rel="external NoFollow">s</a><a href="/keep" rel="external">k</a>`,
+			want: []string{"http://example.com/keep"},
+		},
+		{
+			name: "meta robots nofollow",
+			body: `<head><meta name="Robots" content="noindex, nofollow"></head><a href="/a">a</a>`,
+			want: []string{},
+		},
+		{
+			name: "meta robots none",
+			body: `<head><meta name="robots" content="none"></head><a href="/a">a</a>`,
+			want: []string{},
+		},
+		{
+			name:   "x-robots-tag nofollow",
+			header: http.Header{"X-Robots-Tag": {"nofollow"}},
+			body:   `<a href="/a">a</a>`,
+			want:   []string{},
+		},
+		{
+			name:   "no content type",
+			header: http.Header{},
+			body:   `<a href="/a">a</a>`,
+			want:   []string{"http://example.com/a"},
+		},
+		{
+			name:   "not html",
+			header: http.Header{"Content-Type": {"application/json"}},
+			body:   `<a href="/a">a</a>`,
+			want:   []string{},
+		},
+	}
+	parser := NewLinkParser()
+	for _, test := range tests {
+		res := newTestResponse(t, "http://example.com/dir/page", test.header, test.body)
+		dataList, errs := parser(res)
+		if len(errs) > 0 {
+			t.Errorf("%s: unexpected errors: %v", test.name, errs)
+			continue
+		}
+		got := make([]string, 0, len(dataList))
+		for _, data := range dataList {
+			req, ok := data.(*base.Request)
+			if !ok {
+				t.Errorf("%s: unexpected data type %T", test.name, data)
+				continue
+			}
+			if req.Depth() != 1 {
+				t.Errorf("%s: depth of %s = %d, want 1", test.name, req.Get().URL, req.Depth())
+			}
+			got = append(got, req.Get().URL.String())
+		}
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%s: links = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"gocrawler/base"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// the number of bytes of a body matched against the body patterns
+var loginBodyLimit int64 = 1024 * 1024
+
+type LoginArgs struct {
+	//the url of the page with the login form
+	LoginUrl string
+	//the css selector of the login form, the first form in the page if empty
+	FormSelector string
+	//the values of the named fields, the other fields keep the values in the page,
+	//e.g. the hidden csrf tokens
+	Fields map[string]string
+	//the status code of a successful login, 0 means any status below 400
+	SuccessStatus int
+	//the pattern the url must match after a successful login, e.g. after the redirects
+	SuccessUrlPattern string
+	//the pattern the body must match after a successful login
+	SuccessBodyPattern string
+	//the status codes of the responses which mean the session expired, e.g. 401
+	ExpiredStatus []int
+	//the pattern of the url a response is redirected to when the session expired
+	ExpiredUrlPattern string
+	//the pattern of the body of a response when the session expired
+	ExpiredBodyPattern string
+}
+
+// the state of the login of a session, generation is 0 until the first login
+type loginState struct {
+	mutex      sync.Mutex
+	generation uint64
+}
+
+// loginManager logs the sessions in with the login form, the cookies it gets are kept
+// in the jars of the sessions
+type loginManager struct {
+	args          LoginArgs
+	genHttpClient GenHttpClient
+	sessions      CookieSessions
+	successUrl    *regexp.Regexp
+	successBody   *regexp.Regexp
+	expiredUrl    *regexp.Regexp
+	expiredBody   *regexp.Regexp
+	states        map[string]*loginState
+	mutex         sync.Mutex
+}
+
+func newLoginManager(args LoginArgs, genHttpClient GenHttpClient, sessions CookieSessions) (*loginManager, error) {
+	if _, err := url.Parse(args.LoginUrl); err != nil || args.LoginUrl == "" {
+		errMsg := fmt.Sprintf("Invalid login url: %s", args.LoginUrl)
+		return nil, errors.New(errMsg)
+	}
+	if args.FormSelector == "" {
+		args.FormSelector = "form"
+	}
+	l := &loginManager{
+		args:          args,
+		genHttpClient: genHttpClient,
+		sessions:      sessions,
+		states:        make(map[string]*loginState),
+	}
+	patterns := []struct {
+		pattern string
+		regexp  **regexp.Regexp
+	}{
+		{args.SuccessUrlPattern, &l.successUrl},
+		{args.SuccessBodyPattern, &l.successBody},
+		{args.ExpiredUrlPattern, &l.expiredUrl},
+		{args.ExpiredBodyPattern, &l.expiredBody},
+	}
+	for _, p := range patterns {
+		if p.pattern == "" {
+			continue
+		}
+		compiled, err := regexp.Compile(p.pattern)
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid login pattern %q: %s", p.pattern, err)
+			return nil, errors.New(errMsg)
+		}
+		*p.regexp = compiled
+	}
+	return l, nil
+}
+
+func (l *loginManager) state(session string) *loginState {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	state, ok := l.states[session]
+	if !ok {
+		state = &loginState{}
+		l.states[session] = state
+	}
+	return state
+}
+
+// ensure logs the session in if it has never been, and returns the generation of its login
+func (l *loginManager) ensure(ctx context.Context, session string) (uint64, error) {
+	state := l.state(session)
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.generation == 0 {
+		if err := l.login(ctx, session); err != nil {
+			return 0, err
+		}
+		state.generation++
+	}
+	return state.generation, nil
+}
+
+// relogin logs the session in again unless another download did it after the given generation
+func (l *loginManager) relogin(ctx context.Context, session string, generation uint64) error {
+	state := l.state(session)
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.generation != generation {
+		return nil
+	}
+	if err := l.login(ctx, session); err != nil {
+		return err
+	}
+	state.generation++
+	return nil
+}
+
+func (l *loginManager) login(ctx context.Context, session string) error {
+	httpClient := l.genHttpClient()
+	client := *httpClient
+	client.Jar = l.sessions.Jar(session)
+
+	pageReq, err := http.NewRequestWithContext(ctx, http.MethodGet, l.args.LoginUrl, nil)
+	if err != nil {
+		return l.loginError(err, "")
+	}
+	pageRes, err := client.Do(pageReq)
+	if err != nil {
+		return l.loginError(err, "")
+	}
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(pageRes.Body, loginBodyLimit))
+	pageRes.Body.Close()
+	if err != nil {
+		return l.loginError(err, "")
+	}
+	form := doc.Find(l.args.FormSelector).First()
+	if form.Length() == 0 {
+		errMsg := fmt.Sprintf("The login form is not found! (selector=%s)", l.args.FormSelector)
+		return l.loginError(nil, errMsg)
+	}
+	actionUrl, err := pageRes.Request.URL.Parse(strings.TrimSpace(form.AttrOr("action", "")))
+	if err != nil {
+		return l.loginError(err, "")
+	}
+	values := formValues(form)
+	for name, value := range l.args.Fields {
+		values.Set(name, value)
+	}
+
+	var submitReq *http.Request
+	if method := strings.ToUpper(form.AttrOr("method", http.MethodGet)); method == http.MethodPost {
+		submitReq, err = http.NewRequestWithContext(ctx, http.MethodPost, actionUrl.String(),
+			strings.NewReader(values.Encode()))
+		if err == nil {
+			submitReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		actionUrl.RawQuery = values.Encode()
+		submitReq, err = http.NewRequestWithContext(ctx, http.MethodGet, actionUrl.String(), nil)
+	}
+	if err != nil {
+		return l.loginError(err, "")
+	}
+	submitRes, err := client.Do(submitReq)
+	if err != nil {
+		return l.loginError(err, "")
+	}
+	defer submitRes.Body.Close()
+	return l.verify(submitRes)
+}
+
+func (l *loginManager) verify(res *http.Response) error {
+	if l.args.SuccessStatus != 0 && res.StatusCode != l.args.SuccessStatus ||
+		l.args.SuccessStatus == 0 && res.StatusCode >= 400 {
+		errMsg := fmt.Sprintf("The login failed with status %d!", res.StatusCode)
+		return l.loginError(nil, errMsg)
+	}
+	finalUrl := res.Request.URL.String()
+	if l.successUrl != nil && !l.successUrl.MatchString(finalUrl) {
+		errMsg := fmt.Sprintf("The url after the login doesn't match! (url=%s)", finalUrl)
+		return l.loginError(nil, errMsg)
+	}
+	if l.successBody != nil {
+		body, err := io.ReadAll(io.LimitReader(res.Body, loginBodyLimit))
+		if err != nil {
+			return l.loginError(err, "")
+		}
+		if !l.successBody.Match(body) {
+			return l.loginError(nil, "The body after the login doesn't match!")
+		}
+	}
+	return nil
+}
+
+func (l *loginManager) loginError(cause error, errMsg string) error {
+	return base.NewCrawlerErrorWithDetail(base.LOGIN_ERROR, errMsg, base.ErrorDetail{
+		Cause:     cause,
+		Url:       l.args.LoginUrl,
+		Retryable: base.IsRetryable(cause),
+	})
+}
+
+// expired tells whether the response means the session expired, the body read for the
+// check is put back
+func (l *loginManager) expired(res *http.Response) bool {
+	for _, status := range l.args.ExpiredStatus {
+		if res.StatusCode == status {
+			return true
+		}
+	}
+	if l.expiredUrl != nil && res.Request != nil && l.expiredUrl.MatchString(res.Request.URL.String()) {
+		return true
+	}
+	if l.expiredBody != nil && res.Body != nil {
+		head, err := io.ReadAll(io.LimitReader(res.Body, loginBodyLimit))
+		res.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head), res.Body), res.Body}
+		return err == nil && l.expiredBody.Match(head)
+	}
+	return false
+}
+
+// formValues collects the values the form submits as is, the buttons are skipped
+func formValues(form *goquery.Selection) url.Values {
+	values := url.Values{}
+	form.Find("input, select, textarea").Each(func(_ int, field *goquery.Selection) {
+		name := field.AttrOr("name", "")
+		if _, disabled := field.Attr("disabled"); name == "" || disabled {
+			return
+		}
+		switch goquery.NodeName(field) {
+		case "input":
+			switch strings.ToLower(field.AttrOr("type", "text")) {
+			case "submit", "button", "image", "reset", "file":
+			case "checkbox", "radio":
+				if _, checked := field.Attr("checked"); checked {
+					values.Add(name, field.AttrOr("value", "on"))
+				}
+			default:
+				values.Add(name, field.AttrOr("value", ""))
+			}
+		case "select":
+			option := field.Find("option[selected]").First()
+			if option.Length() == 0 {
+				option = field.Find("option").First()
+			}
+			if option.Length() > 0 {
+				values.Add(name, option.AttrOr("value", strings.TrimSpace(option.Text())))
+			}
+		case "textarea":
+			values.Add(name, field.Text())
+		}
+	})
+	return values
+}
+
+type loginPageDownloader struct {
+	downloader PageDownloader
+	logins     *loginManager
+}
+
+// newLoginPageDownloader logs the session of a request in before downloading it, and logs
+// in again and downloads the request once more if the response means the session expired
+func newLoginPageDownloader(downloader PageDownloader, logins *loginManager) PageDownloader {
+	return &loginPageDownloader{
+		downloader: downloader,
+		logins:     logins,
+	}
+}
+
+func (m *loginPageDownloader) Id() uint32 {
+	return m.downloader.Id()
+}
+
+func (m *loginPageDownloader) Download(req base.Request) (*base.Response, error) {
+	return m.DownloadContext(context.Background(), req)
+}
+
+func (m *loginPageDownloader) DownloadContext(ctx context.Context, req base.Request) (*base.Response, error) {
+	generation, err := m.logins.ensure(ctx, req.Session())
+	if err != nil {
+		return nil, err
+	}
+	res, err := m.downloader.DownloadContext(ctx, req)
+	if err != nil || res == nil || res.Get() == nil || !m.logins.expired(res.Get()) || !canRetry(req) {
+		return res, err
+	}
+	discardBody(res.Get())
+	if err := m.logins.relogin(ctx, req.Session(), generation); err != nil {
+		return nil, err
+	}
+	if httpReq := req.Get(); httpReq.Body != nil && httpReq.Body != http.NoBody {
+		body, err := httpReq.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retryReq := httpReq.Clone(httpReq.Context())
+		retryReq.Body = body
+		attempt, session := req.Attempt(), req.Session()
+		req = *base.NewRequest(retryReq, req.Depth())
+		req.SetAttempt(attempt)
+		req.SetSession(session)
+	}
+	return m.downloader.DownloadContext(ctx, req)
+}
@@ -0,0 +1,158 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"gocrawler/base"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// testLoginServer serves a login form and the pages which need the session cookie of the
+// last login, expire makes the session invalid
+type testLoginServer struct {
+	*httptest.Server
+	logins  int64
+	session string
+	mutex   sync.Mutex
+}
+
+func newTestLoginServer() *testLoginServer {
+	server := &testLoginServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><form method="post" action="/do-login">
+			<input type="hidden" name="csrf" value="token-1">
+			<input type="text" name="user"><input type="password" name="password">
+			<input type="submit" value="Log in"></form></html>`)
+	})
+	mux.HandleFunc("/do-login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.FormValue("csrf") != "token-1" ||
+			r.FormValue("user") != "alice" || r.FormValue("password") != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		logins := atomic.AddInt64(&server.logins, 1)
+		server.mutex.Lock()
+		server.session = fmt.Sprintf("session-%d", logins)
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: server.session, Path: "/"})
+		server.mutex.Unlock()
+		http.Redirect(w, r, "/home", http.StatusFound)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session")
+		server.mutex.Lock()
+		valid := err == nil && server.session != "" && cookie.Value == server.session
+		server.mutex.Unlock()
+		if !valid {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, "<html>%s</html>", r.URL.Path)
+	})
+	server.Server = httptest.NewServer(mux)
+	return server
+}
+
+func (s *testLoginServer) expire() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.session = ""
+}
+
+func newTestLoginDownloader(t *testing.T, server *testLoginServer, password string) PageDownloader {
+	sessions := NewCookieSessions()
+	logins, err := newLoginManager(LoginArgs{
+		LoginUrl:          server.URL + "/login",
+		Fields:            map[string]string{"user": "alice", "password": password},
+		SuccessUrlPattern: "/home$",
+		ExpiredStatus:     []int{http.StatusUnauthorized},
+	}, genTestClient, sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newLoginPageDownloader(NewSessionPageDownloader(genTestClient(), sessions), logins)
+}
+
+func downloadTestPage(t *testing.T, downloader PageDownloader, rawUrl string) (int, error) {
+	res, err := downloader.Download(*newTestRequest(t, rawUrl, 0))
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, res.Get().Body)
+	res.Get().Body.Close()
+	return res.Get().StatusCode, nil
+}
+
+func TestLoginRelogin(t *testing.T) {
+	server := newTestLoginServer()
+	defer server.Close()
+	downloader := newTestLoginDownloader(t, server, "secret")
+	steps := []struct {
+		expire bool
+		logins int64
+	}{
+		//the session is logged in before its first download
+		{false, 1},
+		{false, 1},
+		//the expired session is logged in again and the page downloaded once more
+		{true, 2},
+		{false, 2},
+	}
+	for i, step := range steps {
+		if step.expire {
+			server.expire()
+		}
+		status, err := downloadTestPage(t, downloader, fmt.Sprintf("%s/page%d", server.URL, i))
+		if err != nil || status != http.StatusOK {
+			t.Errorf("step %d: download = %d, %v, want 200", i, status, err)
+		}
+		if got := atomic.LoadInt64(&server.logins); got != step.logins {
+			t.Errorf("step %d: %d logins, want %d", i, got, step.logins)
+		}
+	}
+}
+
+func TestLoginReloginOnce(t *testing.T) {
+	server := newTestLoginServer()
+	defer server.Close()
+	downloader := newTestLoginDownloader(t, server, "secret")
+	if _, err := downloadTestPage(t, downloader, server.URL+"/first"); err != nil {
+		t.Fatal(err)
+	}
+	server.expire()
+	//the downloads which find the session expired at the same time log it in once
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			status, err := downloadTestPage(t, downloader, fmt.Sprintf("%s/page%d", server.URL, i))
+			if err != nil || status != http.StatusOK {
+				t.Errorf("page %d: download = %d, %v, want 200", i, status, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := atomic.LoadInt64(&server.logins); got != 2 {
+		t.Errorf("%d logins, want 2", got)
+	}
+}
+
+func TestLoginFailed(t *testing.T) {
+	server := newTestLoginServer()
+	defer server.Close()
+	downloader := newTestLoginDownloader(t, server, "wrong")
+	_, err := downloadTestPage(t, downloader, server.URL+"/page")
+	var cError base.CrawlerError
+	if !errors.As(err, &cError) || cError.Type() != base.LOGIN_ERROR {
+		t.Errorf("download with a failed login error = %v, want a login error", err)
+	}
+	if got := atomic.LoadInt64(&server.logins); got != 0 {
+		t.Errorf("%d logins, want 0", got)
+	}
+}
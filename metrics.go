@@ -0,0 +1,118 @@
+package crawler
+
+import (
+	"gocrawler/base"
+	"gocrawler/middleware"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// schedMetrics holds the metrics updated by the scheduler, the others are sampled on each scrape
+type schedMetrics struct {
+	downloads       middleware.Counter
+	downloadBytes   middleware.Counter
+	downloadLatency middleware.Histogram
+	errors          middleware.Counter
+}
+
+// WithMetrics exposes the crawler internals through the registry, serve registry.Handler()
+// to let prometheus scrape them
+func WithMetrics(registry middleware.MetricsRegistry) SchedOption {
+	return func(sched *myScheduler) {
+		sched.metrics = newSchedMetrics(registry, sched)
+	}
+}
+
+func newSchedMetrics(registry middleware.MetricsRegistry, sched *myScheduler) *schedMetrics {
+	metrics := &schedMetrics{
+		downloads: registry.Counter("gocrawler_downloads_total",
+			"The number of responses downloaded by status code.", "code"),
+		downloadBytes: registry.Counter("gocrawler_download_bytes_total",
+			"The number of response body bytes fetched."),
+		downloadLatency: registry.Histogram("gocrawler_download_duration_seconds",
+			"The time spent downloading a request, retries included.", nil),
+		errors: registry.Counter("gocrawler_errors_total",
+			"The number of errors by type.", "type"),
+	}
+	poolUsed := registry.Gauge("gocrawler_pool_used", "The number of entities taken from the pool.", "pool")
+	poolTotal := registry.Gauge("gocrawler_pool_total", "The size of the pool.", "pool")
+	channelLength := registry.Gauge("gocrawler_channel_length", "The number of elements in the channel.", "channel")
+	channelCapacity := registry.Gauge("gocrawler_channel_capacity", "The capacity of the channel.", "channel")
+	queueLength := registry.Gauge("gocrawler_request_cache_length", "The number of requests in request cache.")
+	seen := registry.Gauge("gocrawler_seen_urls", "The number of urls in the seen set.")
+	duplicates := registry.Counter("gocrawler_duplicate_requests_total", "The number of duplicate requests dropped.")
+	retries := registry.Counter("gocrawler_retries_total", "The number of retried downloads.")
+	items := registry.Counter("gocrawler_items_total", "The number of items by pipeline stage.", "stage")
+	registry.OnCollect(func() {
+		duplicates.Set(float64(atomic.LoadUint64(&sched.duplicates)))
+		retries.Set(float64(atomic.LoadUint64(&sched.retries)))
+		//components are only available after the scheduler has been started, the ones of
+		//the last run are read while it's started again
+		components := sched.loadComponents()
+		if components == nil {
+			return
+		}
+		poolUsed.Set(float64(components.dlpool.Used()), DOWNLOADER_CODE)
+		poolTotal.Set(float64(components.dlpool.Total()), DOWNLOADER_CODE)
+		poolUsed.Set(float64(components.analyzerPool.Used()), ANALYZER_CODE)
+		poolTotal.Set(float64(components.analyzerPool.Total()), ANALYZER_CODE)
+		if reqChan, err := components.chanman.ReqChan(); err == nil {
+			channelLength.Set(float64(len(reqChan)), "request")
+			channelCapacity.Set(float64(cap(reqChan)), "request")
+		}
+		if resChan, err := components.chanman.ResChan(); err == nil {
+			channelLength.Set(float64(len(resChan)), "response")
+			channelCapacity.Set(float64(cap(resChan)), "response")
+		}
+		if itemChan, err := components.chanman.ItemChan(); err == nil {
+			channelLength.Set(float64(len(itemChan)), "item")
+			channelCapacity.Set(float64(cap(itemChan)), "item")
+		}
+		if errorChan, err := components.chanman.ErrorChan(); err == nil {
+			channelLength.Set(float64(len(errorChan)), "error")
+			channelCapacity.Set(float64(cap(errorChan)), "error")
+		}
+		queueLength.Set(float64(components.reqCache.length()))
+		seen.Set(float64(components.seenSet.Count()))
+		sent, accepted, processed := components.itemPipeline.Count()
+		items.Set(float64(sent), "sent")
+		items.Set(float64(accepted), "accepted")
+		items.Set(float64(processed), "processed")
+	})
+	return metrics
+}
+
+// the bytes are counted as the body is read by the analyzers
+func (m *schedMetrics) observeDownload(res *base.Response, elapsed time.Duration) {
+	m.downloadLatency.Observe(elapsed.Seconds())
+	if res == nil || res.Get() == nil {
+		return
+	}
+	httpRes := res.Get()
+	m.downloads.Inc(strconv.Itoa(httpRes.StatusCode))
+	if httpRes.Body != nil {
+		httpRes.Body = &countingBody{ReadCloser: httpRes.Body, counter: m.downloadBytes}
+	}
+}
+
+func (m *schedMetrics) countError(errType base.ErrorType) {
+	if errType == "" {
+		errType = "Unknown Error"
+	}
+	m.errors.Inc(string(errType))
+}
+
+type countingBody struct {
+	io.ReadCloser
+	counter middleware.Counter
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		c.counter.Add(float64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,55 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"gocrawler/middleware"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetricsDuringRestart(t *testing.T) {
+	server := newTestSiteServer(nil)
+	defer server.Close()
+	registry := middleware.NewMetricsRegistry()
+	sched := NewScheduler(WithMetrics(registry))
+	var buf bytes.Buffer
+	registry.WriteTo(&buf)
+	if strings.Contains(buf.String(), "gocrawler_pool_total") {
+		t.Errorf("the pools are scraped before the start:\n%s", buf.String())
+	}
+
+	done := make(chan struct{})
+	var scraper sync.WaitGroup
+	scraper.Add(1)
+	go func() {
+		defer scraper.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				registry.WriteTo(&bytes.Buffer{})
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		startTestScheduler(t, sched, context.Background(), server, 10)
+		sched.Stop()
+	}
+	close(done)
+	scraper.Wait()
+
+	startTestScheduler(t, sched, context.Background(), server, 0)
+	waitIdle(t, sched, 5*time.Second)
+	buf.Reset()
+	registry.WriteTo(&buf)
+	sched.Stop()
+	for _, want := range []string{`gocrawler_pool_total{pool="downloader"} 3`, "gocrawler_seen_urls 1"} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("scraped %q, want %q", buf.String(), want)
+		}
+	}
+}
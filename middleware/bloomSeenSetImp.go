@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"math"
+	"sync"
+)
+
+// bloomSeenSet uses a fixed size bloom filter, the memory is bounded but a key may be
+// reported as seen by mistake with the configured false positive rate
+type bloomSeenSet struct {
+	bits      []uint64
+	bitCount  uint64
+	hashCount uint64
+	count     uint64
+	rwmutex   sync.RWMutex
+}
+
+// expected is the expected number of keys, falsePositiveRate should be in (0, 1)
+func NewBloomSeenSet(expected uint64, falsePositiveRate float64) (PersistentSeenSet, error) {
+	if expected == 0 {
+		return nil, errors.New("The expected number of keys can not be 0!")
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, errors.New("The false positive rate should be between 0 and 1!")
+	}
+	n := float64(expected)
+	m := math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/n*math.Ln2))
+	bitCount := uint64(m)
+	return &bloomSeenSet{
+		bits:      make([]uint64, (bitCount+63)/64),
+		bitCount:  bitCount,
+		hashCount: uint64(k),
+	}, nil
+}
+
+// fnv doesn't spread similar keys well, so each half is mixed by the splitmix64 finalizer
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// the positions are generated by double hashing from the two halves of a 128 bit fnv hash,
+// must be called with the mutex locked since a loaded filter may change the sizes
+func (m *bloomSeenSet) positions(key string) []uint64 {
+	h := fnv.New128a()
+	h.Write([]byte(key))
+	sum := h.Sum(nil)
+	h1 := mix64(binary.BigEndian.Uint64(sum[:8]))
+	h2 := mix64(binary.BigEndian.Uint64(sum[8:])) | 1
+	result := make([]uint64, m.hashCount)
+	for i := uint64(0); i < m.hashCount; i++ {
+		result[i] = (h1 + i*h2) % m.bitCount
+	}
+	return result
+}
+
+func (m *bloomSeenSet) Add(key string) bool {
+	m.rwmutex.Lock()
+	defer m.rwmutex.Unlock()
+	positions := m.positions(key)
+	added := false
+	for _, p := range positions {
+		mask := uint64(1) << (p % 64)
+		if m.bits[p/64]&mask == 0 {
+			m.bits[p/64] |= mask
+			added = true
+		}
+	}
+	if added {
+		m.count++
+	}
+	return added
+}
+
+func (m *bloomSeenSet) Contains(key string) bool {
+	m.rwmutex.RLock()
+	defer m.rwmutex.RUnlock()
+	for _, p := range m.positions(key) {
+		if m.bits[p/64]&(uint64(1)<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *bloomSeenSet) Count() uint64 {
+	m.rwmutex.RLock()
+	defer m.rwmutex.RUnlock()
+	return m.count
+}
+
+func (m *bloomSeenSet) Reset() {
+	m.rwmutex.Lock()
+	defer m.rwmutex.Unlock()
+	m.bits = make([]uint64, (m.bitCount+63)/64)
+	m.count = 0
+}
+
+func (m *bloomSeenSet) Summary() string {
+	return m.Info().String()
+}
+
+func (m *bloomSeenSet) Info() SeenSetInfo {
+	return SeenSetInfo{Type: "bloom", Count: m.Count(), Bits: m.bitCount, Hashes: m.hashCount}
+}
+
+// the bit count, hash count and key count are saved before the bits, all in big endian
+func (m *bloomSeenSet) Save(w io.Writer) error {
+	m.rwmutex.RLock()
+	defer m.rwmutex.RUnlock()
+	header := []uint64{m.bitCount, m.hashCount, m.count}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, m.bits)
+}
+
+// the saved filter replaces the current one even if it was created with other parameters
+func (m *bloomSeenSet) Load(r io.Reader) error {
+	header := make([]uint64, 3)
+	if err := binary.Read(r, binary.BigEndian, header); err != nil {
+		return err
+	}
+	bitCount, hashCount := header[0], header[1]
+	if bitCount == 0 || hashCount == 0 {
+		return errors.New("The saved bloom filter is invalid!")
+	}
+	bits := make([]uint64, (bitCount+63)/64)
+	if err := binary.Read(r, binary.BigEndian, bits); err != nil {
+		return err
+	}
+	m.rwmutex.Lock()
+	defer m.rwmutex.Unlock()
+	m.bits = bits
+	m.bitCount = bitCount
+	m.hashCount = hashCount
+	m.count = header[2]
+	return nil
+}
@@ -99,7 +99,9 @@ func (m *myChannelManager) ErrorChan() (chan error, error) {
 }
 
 func (m *myChannelManager) Summary() string {
-	summary := fmt.Sprintf(chanSummaryTemplate, statusNameMap[m.status], m.reqChan,
+	m.rwmutex.RLock()
+	defer m.rwmutex.RUnlock()
+	summary := fmt.Sprintf(chanSummaryTemplate, statusNameMap[m.status],
 		len(m.reqChan), cap(m.reqChan),
 		len(m.resChan), cap(m.resChan),
 		len(m.itemChan), cap(m.itemChan),
@@ -113,6 +115,8 @@ func (m *myChannelManager) ChannelLen() uint32 {
 }
 
 func (m *myChannelManager) Status() ChannelManagerStatus {
+	m.rwmutex.RLock()
+	defer m.rwmutex.RUnlock()
 	return m.status
 }
 
@@ -21,6 +21,10 @@ var statusNameMap = map[ChannelManagerStatus]string{
 	CHANNEL_MANAGER_STATUS_CLOSED:        "closed",
 }
 
+func (s ChannelManagerStatus) String() string {
+	return statusNameMap[s]
+}
+
 var chanSummaryTemplate = "status: %s, " +
 	"request channel: %d/%d, " +
 	"response channel: %d/%d, " +
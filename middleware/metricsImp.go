@@ -0,0 +1,288 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	METRIC_TYPE_COUNTER   = "counter"
+	METRIC_TYPE_GAUGE     = "gauge"
+	METRIC_TYPE_HISTOGRAM = "histogram"
+)
+
+var metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// series is the value of a metric with one set of label values
+type series struct {
+	labelValues []string
+	value       float64
+	//only used by histograms, counts[i] is the number of values in buckets[i]
+	counts []uint64
+	count  uint64
+}
+
+type metric struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	buckets    []float64
+	series     map[string]*series
+	mutex      sync.Mutex
+}
+
+type myMetricsRegistry struct {
+	metrics    map[string]*metric
+	collectors []func()
+	mutex      sync.Mutex
+}
+
+func NewMetricsRegistry() MetricsRegistry {
+	return &myMetricsRegistry{metrics: make(map[string]*metric)}
+}
+
+func (m *myMetricsRegistry) register(name string, help string, metricType string,
+	buckets []float64, labelNames []string) *metric {
+	if !metricNamePattern.MatchString(name) {
+		panic(errors.New("Invalid metric name: " + name))
+	}
+	for _, labelName := range labelNames {
+		if !labelNamePattern.MatchString(labelName) || labelName == "le" {
+			errMsg := fmt.Sprintf("Invalid label name! (metric=%s, label=%s)", name, labelName)
+			panic(errors.New(errMsg))
+		}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if existing, ok := m.metrics[name]; ok {
+		if existing.metricType != metricType || len(existing.labelNames) != len(labelNames) {
+			errMsg := fmt.Sprintf("The metric has been registered as another type! (metric=%s, type=%s)",
+				name, existing.metricType)
+			panic(errors.New(errMsg))
+		}
+		return existing
+	}
+	newMetric := &metric{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: append([]string(nil), labelNames...),
+		buckets:    buckets,
+		series:     make(map[string]*series),
+	}
+	m.metrics[name] = newMetric
+	return newMetric
+}
+
+func (m *myMetricsRegistry) Counter(name string, help string, labelNames ...string) Counter {
+	return &myCounter{m.register(name, help, METRIC_TYPE_COUNTER, nil, labelNames)}
+}
+
+func (m *myMetricsRegistry) Gauge(name string, help string, labelNames ...string) Gauge {
+	return &myGauge{m.register(name, help, METRIC_TYPE_GAUGE, nil, labelNames)}
+}
+
+func (m *myMetricsRegistry) Histogram(name string, help string, buckets []float64, labelNames ...string) Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &myHistogram{m.register(name, help, METRIC_TYPE_HISTOGRAM, buckets, labelNames)}
+}
+
+func (m *myMetricsRegistry) OnCollect(collect func()) {
+	if collect == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.collectors = append(m.collectors, collect)
+}
+
+func (m *myMetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	m.mutex.Lock()
+	collectors := append([]func(){}, m.collectors...)
+	metrics := make([]*metric, 0, len(m.metrics))
+	for _, metric := range m.metrics {
+		metrics = append(metrics, metric)
+	}
+	m.mutex.Unlock()
+	for _, collect := range collectors {
+		collect()
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name < metrics[j].name
+	})
+	var buf bytes.Buffer
+	for _, metric := range metrics {
+		metric.write(&buf)
+	}
+	return buf.WriteTo(w)
+}
+
+func (m *myMetricsRegistry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		m.WriteTo(bw)
+		bw.Flush()
+	})
+}
+
+// get the series of the label values, it's created if absent. The mutex must be locked
+func (m *metric) seriesOf(labelValues []string) *series {
+	if len(labelValues) != len(m.labelNames) {
+		errMsg := fmt.Sprintf("The number of label values is wrong! (metric=%s, expected=%d, actual=%d)",
+			m.name, len(m.labelNames), len(labelValues))
+		panic(errors.New(errMsg))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if m.metricType == METRIC_TYPE_HISTOGRAM {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metric) write(buf *bytes.Buffer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.series) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(buf, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", m.name, m.metricType)
+	for _, key := range keys {
+		s := m.series[key]
+		if m.metricType != METRIC_TYPE_HISTOGRAM {
+			fmt.Fprintf(buf, "%s%s %s\n", m.name, m.labels(s.labelValues, ""), formatValue(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", m.name, m.labels(s.labelValues, formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", m.name, m.labels(s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", m.name, m.labels(s.labelValues, ""), formatValue(s.value))
+		fmt.Fprintf(buf, "%s_count%s %d\n", m.name, m.labels(s.labelValues, ""), s.count)
+	}
+}
+
+// le is the bound of a histogram bucket, it's omitted if empty
+func (m *metric) labels(labelValues []string, le string) string {
+	pairs := make([]string, 0, len(labelValues)+1)
+	for i, value := range labelValues {
+		pairs = append(pairs, m.labelNames[i]+"=\""+escapeLabelValue(value)+"\"")
+	}
+	if le != "" {
+		pairs = append(pairs, "le=\""+le+"\"")
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+type myCounter struct {
+	metric *metric
+}
+
+func (c *myCounter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *myCounter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(errors.New("The delta of a counter can not be negative! (metric=" + c.metric.name + ")"))
+	}
+	c.metric.mutex.Lock()
+	defer c.metric.mutex.Unlock()
+	c.metric.seriesOf(labelValues).value += delta
+}
+
+func (c *myCounter) Set(value float64, labelValues ...string) {
+	c.metric.mutex.Lock()
+	defer c.metric.mutex.Unlock()
+	c.metric.seriesOf(labelValues).value = value
+}
+
+type myGauge struct {
+	metric *metric
+}
+
+func (g *myGauge) Set(value float64, labelValues ...string) {
+	g.metric.mutex.Lock()
+	defer g.metric.mutex.Unlock()
+	g.metric.seriesOf(labelValues).value = value
+}
+
+func (g *myGauge) Add(delta float64, labelValues ...string) {
+	g.metric.mutex.Lock()
+	defer g.metric.mutex.Unlock()
+	g.metric.seriesOf(labelValues).value += delta
+}
+
+type myHistogram struct {
+	metric *metric
+}
+
+func (h *myHistogram) Observe(value float64, labelValues ...string) {
+	h.metric.mutex.Lock()
+	defer h.metric.mutex.Unlock()
+	s := h.metric.seriesOf(labelValues)
+	//the value falls in the first bucket whose bound is not less than it, or only in +Inf
+	i := sort.SearchFloat64s(h.metric.buckets, value)
+	if i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.count++
+	s.value += value
+}
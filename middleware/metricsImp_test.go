@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func scrapeTestRegistry(t *testing.T, registry MetricsRegistry) string {
+	var buf bytes.Buffer
+	if _, err := registry.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestCounterSet(t *testing.T) {
+	registry := NewMetricsRegistry()
+	counter := registry.Counter("test_total", "The test counter.", "stage")
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{3, `test_total{stage="sent"} 3`},
+		{5, `test_total{stage="sent"} 5`},
+		//the source is counted from 0 again, e.g. after a restart
+		{1, `test_total{stage="sent"} 1`},
+	}
+	for _, test := range tests {
+		counter.Set(test.value, "sent")
+		if got := scrapeTestRegistry(t, registry); !strings.Contains(got, test.want+"\n") {
+			t.Errorf("Set(%v): scraped %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestCounterAddNegative(t *testing.T) {
+	counter := NewMetricsRegistry().Counter("test_total", "The test counter.")
+	defer func() {
+		if recover() == nil {
+			t.Error("Add of a negative delta doesn't panic")
+		}
+	}()
+	counter.Add(-1)
+}
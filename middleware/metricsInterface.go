@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+)
+
+// the default buckets of histograms, in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Counter is a value which only goes up, e.g. the number of downloaded pages
+type Counter interface {
+	//add 1, the label values must be given in the order of the label names
+	Inc(labelValues ...string)
+	//add the delta, which must not be negative
+	Add(delta float64, labelValues ...string)
+	//set the value counted elsewhere. A lower value is a reset of the counter, e.g. the
+	//source is counted from 0 again after a restart
+	Set(value float64, labelValues ...string)
+}
+
+// Gauge is a value which goes up and down, e.g. the length of a channel
+type Gauge interface {
+	Set(value float64, labelValues ...string)
+	Add(delta float64, labelValues ...string)
+}
+
+// Histogram counts the observed values in buckets, e.g. the download latency
+type Histogram interface {
+	Observe(value float64, labelValues ...string)
+}
+
+// MetricsRegistry holds the metrics and writes them in the prometheus text exposition format.
+// Registering a name again returns the metric registered first if the type is the same
+type MetricsRegistry interface {
+	//register a counter
+	Counter(name string, help string, labelNames ...string) Counter
+	//register a gauge
+	Gauge(name string, help string, labelNames ...string) Gauge
+	//register a histogram, DefaultBuckets are used if buckets is empty
+	Histogram(name string, help string, buckets []float64, labelNames ...string) Histogram
+	//register a func called before each write, e.g. to set the gauges sampled from components
+	OnCollect(collect func())
+	//write all metrics
+	WriteTo(w io.Writer) (int64, error)
+	//get the http handler which serves the metrics
+	Handler() http.Handler
+}
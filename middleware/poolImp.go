@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"errors"
 	"reflect"
 	"sync"
@@ -49,7 +50,17 @@ func NewPool(
 
 //what if the container is empty?
 func (m *myPool) Take() (Entity, error) {
-	entity, ok := <-m.container
+	return m.TakeContext(context.Background())
+}
+
+func (m *myPool) TakeContext(ctx context.Context) (Entity, error) {
+	var entity Entity
+	var ok bool
+	select {
+	case entity, ok = <-m.container:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 	if !ok {
 		errMsg := "The container has been closed!"
 		return nil, errors.New(errMsg)
@@ -1,11 +1,17 @@
 package middleware
 
+import (
+	"context"
+)
+
 type Entity interface {
 	Id() uint32
 }
 
 type Pool interface {
 	Take() (Entity, error)
+	//take an entity, give up when the context is done before any entity is available
+	TakeContext(ctx context.Context) (Entity, error)
 	Return(entity Entity) error
 	Total() uint32
 	Used() uint32
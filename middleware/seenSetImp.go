@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// memorySeenSet keeps every key in memory, it's exact but the memory grows with the keys
+type memorySeenSet struct {
+	keys    map[string]struct{}
+	rwmutex sync.RWMutex
+}
+
+func NewMemorySeenSet() PersistentSeenSet {
+	return &memorySeenSet{
+		keys: make(map[string]struct{}),
+	}
+}
+
+func (m *memorySeenSet) Add(key string) bool {
+	m.rwmutex.Lock()
+	defer m.rwmutex.Unlock()
+	if _, ok := m.keys[key]; ok {
+		return false
+	}
+	m.keys[key] = struct{}{}
+	return true
+}
+
+func (m *memorySeenSet) Contains(key string) bool {
+	m.rwmutex.RLock()
+	defer m.rwmutex.RUnlock()
+	_, ok := m.keys[key]
+	return ok
+}
+
+func (m *memorySeenSet) Count() uint64 {
+	m.rwmutex.RLock()
+	defer m.rwmutex.RUnlock()
+	return uint64(len(m.keys))
+}
+
+func (m *memorySeenSet) Reset() {
+	m.rwmutex.Lock()
+	defer m.rwmutex.Unlock()
+	m.keys = make(map[string]struct{})
+}
+
+func (m *memorySeenSet) Summary() string {
+	return m.Info().String()
+}
+
+func (m *memorySeenSet) Info() SeenSetInfo {
+	return SeenSetInfo{Type: "memory", Count: m.Count()}
+}
+
+// keys are saved one per line, urls never contain a line break
+func (m *memorySeenSet) Save(w io.Writer) error {
+	m.rwmutex.RLock()
+	defer m.rwmutex.RUnlock()
+	writer := bufio.NewWriter(w)
+	for key := range m.keys {
+		if _, err := writer.WriteString(key + "\n"); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+func (m *memorySeenSet) Load(r io.Reader) error {
+	keys := make(map[string]struct{})
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if key := scanner.Text(); key != "" {
+			keys[key] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	m.rwmutex.Lock()
+	defer m.rwmutex.Unlock()
+	m.keys = keys
+	return nil
+}
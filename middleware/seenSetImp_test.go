@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func newTestSeenSets(t *testing.T) map[string]func() PersistentSeenSet {
+	return map[string]func() PersistentSeenSet{
+		"memory": NewMemorySeenSet,
+		"bloom": func() PersistentSeenSet {
+			seenSet, err := NewBloomSeenSet(1000, 0.001)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return seenSet
+		},
+	}
+}
+
+func TestSeenSet(t *testing.T) {
+	for name, newSeenSet := range newTestSeenSets(t) {
+		seenSet := newSeenSet()
+		if !seenSet.Add("http://example.com/a") {
+			t.Errorf("%s: Add of a new key = false, want true", name)
+		}
+		if seenSet.Add("http://example.com/a") {
+			t.Errorf("%s: Add of a seen key = true, want false", name)
+		}
+		if !seenSet.Contains("http://example.com/a") {
+			t.Errorf("%s: Contains of a seen key = false, want true", name)
+		}
+		if seenSet.Contains("http://example.com/b") {
+			t.Errorf("%s: Contains of a new key = true, want false", name)
+		}
+		if count := seenSet.Count(); count != 1 {
+			t.Errorf("%s: Count = %d, want 1", name, count)
+		}
+		seenSet.Reset()
+		if seenSet.Contains("http://example.com/a") || seenSet.Count() != 0 {
+			t.Errorf("%s: the key is still seen after Reset", name)
+		}
+	}
+}
+
+func TestSeenSetSaveLoad(t *testing.T) {
+	for name, newSeenSet := range newTestSeenSets(t) {
+		seenSet := newSeenSet()
+		for i := 0; i < 100; i++ {
+			seenSet.Add(fmt.Sprintf("http://example.com/%d", i))
+		}
+		var buf bytes.Buffer
+		if err := seenSet.Save(&buf); err != nil {
+			t.Fatalf("%s: Save: %s", name, err)
+		}
+		loaded := newSeenSet()
+		loaded.Add("http://example.com/other")
+		if err := loaded.Load(&buf); err != nil {
+			t.Fatalf("%s: Load: %s", name, err)
+		}
+		if count := loaded.Count(); count != 100 {
+			t.Errorf("%s: Count after Load = %d, want 100", name, count)
+		}
+		for i := 0; i < 100; i++ {
+			if key := fmt.Sprintf("http://example.com/%d", i); !loaded.Contains(key) {
+				t.Errorf("%s: Contains(%q) after Load = false, want true", name, key)
+			}
+		}
+		if loaded.Contains("http://example.com/other") {
+			t.Errorf("%s: the key added before Load is still seen", name)
+		}
+	}
+}
+
+func TestBloomSeenSetArgs(t *testing.T) {
+	tests := []struct {
+		expected          uint64
+		falsePositiveRate float64
+		wantErr           bool
+	}{
+		{1000, 0.01, false},
+		{1, 0.5, false},
+		{0, 0.01, true},
+		{1000, 0, true},
+		{1000, 1, true},
+		{1000, -0.1, true},
+	}
+	for _, test := range tests {
+		_, err := NewBloomSeenSet(test.expected, test.falsePositiveRate)
+		if (err != nil) != test.wantErr {
+			t.Errorf("NewBloomSeenSet(%d, %v) error = %v, want error %v",
+				test.expected, test.falsePositiveRate, err, test.wantErr)
+		}
+	}
+	seenSet, _ := NewBloomSeenSet(1000, 0.01)
+	if err := seenSet.Load(bytes.NewReader(make([]byte, 24))); err == nil {
+		t.Errorf("Load of an empty header error = nil, want error")
+	}
+}
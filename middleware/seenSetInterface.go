@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+)
+
+// SeenSet records the keys which have been seen, it's used to drop duplicate requests
+type SeenSet interface {
+	//add the key, return false if the key has been seen already
+	Add(key string) bool
+	//whether the key has been seen
+	Contains(key string) bool
+	//get the number of keys added
+	Count() uint64
+	//forget all the keys
+	Reset()
+	//get the summary info
+	Summary() string
+}
+
+// PersistentSeenSet can be saved to and restored from a checkpoint
+type PersistentSeenSet interface {
+	SeenSet
+	//write all seen keys
+	Save(w io.Writer) error
+	//replace the seen keys by the saved ones
+	Load(r io.Reader) error
+}
+
+// SeenSetInfo is the structured summary info of a seen set
+type SeenSetInfo struct {
+	//e.g. memory or bloom, custom for the seen sets which can't describe themselves
+	Type  string `json:"type"`
+	Count uint64 `json:"count"`
+	//the number of bits and hash functions of a bloom filter, 0 for the other seen sets
+	Bits   uint64 `json:"bits,omitempty"`
+	Hashes uint64 `json:"hashes,omitempty"`
+}
+
+// e.g. "type: bloom, count: 10, bits: 9586, hashes: 7"
+func (i SeenSetInfo) String() string {
+	summary := fmt.Sprintf("type: %s, count: %d", i.Type, i.Count)
+	if i.Bits > 0 {
+		summary += fmt.Sprintf(", bits: %d, hashes: %d", i.Bits, i.Hashes)
+	}
+	return summary
+}
+
+// DescribedSeenSet can describe itself in structured form, the seen sets of this package
+// implement it
+type DescribedSeenSet interface {
+	SeenSet
+	//get the structured summary info
+	Info() SeenSetInfo
+}
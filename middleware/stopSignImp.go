@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"fmt"
 	"sync"
 )
 
@@ -28,6 +29,8 @@ func (m *myStopSign) Sign() bool {
 }
 
 func (m *myStopSign) Signed() bool {
+	m.rwmutex.RLock()
+	defer m.rwmutex.RUnlock()
 	return m.signed
 }
 
@@ -64,7 +67,7 @@ func (m *myStopSign) DealCount(code string) uint32 {
 
 func (m *myStopSign) DealTotal() uint32 {
 	m.rwmutex.RLock()
-	m.rwmutex.RUnlock()
+	defer m.rwmutex.RUnlock()
 	result := uint32(0)
 	for _, v := range m.dealCountMap {
 		result += v
@@ -72,6 +75,18 @@ func (m *myStopSign) DealTotal() uint32 {
 	return result
 }
 
+func (m *myStopSign) DealCounts() map[string]uint32 {
+	m.rwmutex.RLock()
+	defer m.rwmutex.RUnlock()
+	result := make(map[string]uint32, len(m.dealCountMap))
+	for code, count := range m.dealCountMap {
+		result[code] = count
+	}
+	return result
+}
+
 func (m *myStopSign) Summary() string {
-	return ""
+	m.rwmutex.RLock()
+	defer m.rwmutex.RUnlock()
+	return fmt.Sprintf("signed: %v, dealCount: %v", m.signed, m.dealCountMap)
 }
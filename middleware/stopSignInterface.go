@@ -13,6 +13,8 @@ type StopSign interface {
 	DealCount(code string) uint32
 	//get the total count of stop sign processed by all stop sign processors
 	DealTotal() uint32
+	//get a copy of the stop sign processed counts of all stop sign processors
+	DealCounts() map[string]uint32
 	//get the summary info
 	Summary() string
 }
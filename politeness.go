@@ -0,0 +1,299 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gocrawler/base"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the longest time a waiter sleeps before checking the slot again, in case a release is missed
+var politeRecheckInterval = 100 * time.Millisecond
+
+// the timeout of resolving the ip of a host in per-ip mode
+var politeResolveTimeout = 5 * time.Second
+
+type PolitenessArgs struct {
+	//the minimum delay between the start of two requests to the same host
+	MinDelay time.Duration
+	//the max number of concurrent requests to the same host, 0 means unlimited
+	MaxConcurrency uint32
+	//apply the limits to each ip as well, so hosts sharing one server are throttled together
+	PerIp bool
+	//use the crawl delay of the host as minimum delay if it's larger than MinDelay
+	HonorCrawlDelay bool
+}
+
+// handle is called once the host of the request can accept it, done must be called
+// when the request is finished
+type politeHandler func(req base.Request, done func())
+
+// Politeness throttles the requests to each host before they are downloaded.
+// Each host has its own queue, so a slow host never stalls the requests to other hosts
+type Politeness interface {
+	//queue the request, the handler is called from the worker goroutine of its host.
+	//It never blocks, the caller bounds the requests by Pending
+	Submit(req base.Request, handle politeHandler)
+	//set the crawl delay of a host, e.g. from robots.txt
+	SetCrawlDelay(host string, delay time.Duration)
+	//change the limits, the new limits apply to the requests not started yet
+	SetLimits(minDelay time.Duration, maxConcurrency uint32)
+	//get the limits set by the args or SetLimits
+	Limits() (minDelay time.Duration, maxConcurrency uint32)
+	//get the number of requests queued, waiting or not finished yet
+	Pending() uint64
+	//drop the queued requests and stop all workers, it returns once the workers exit,
+	//so it must not be called from a handler
+	Close()
+	//get the number of requests dropped because of Close
+	Dropped() uint64
+	//get the summary info
+	Summary() string
+}
+
+// politeSlot tracks the requests started for one host or ip
+type politeSlot struct {
+	mutex     sync.Mutex
+	active    uint32
+	lastStart time.Time
+	released  chan struct{}
+}
+
+func newPoliteSlot() *politeSlot {
+	return &politeSlot{released: make(chan struct{}, 1)}
+}
+
+// block until the slot has room and the delay has passed since the last start,
+// return false if closed is closed first
+func (s *politeSlot) acquire(closed <-chan struct{}, delay time.Duration, maxConcurrency uint32) bool {
+	for {
+		s.mutex.Lock()
+		wait := time.Until(s.lastStart.Add(delay))
+		if (maxConcurrency == 0 || s.active < maxConcurrency) && wait <= 0 {
+			s.active++
+			s.lastStart = time.Now()
+			s.mutex.Unlock()
+			return true
+		}
+		s.mutex.Unlock()
+		if wait <= 0 || wait > politeRecheckInterval {
+			wait = politeRecheckInterval
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-closed:
+			timer.Stop()
+			return false
+		case <-s.released:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (s *politeSlot) release() {
+	s.mutex.Lock()
+	if s.active > 0 {
+		s.active--
+	}
+	s.mutex.Unlock()
+	select {
+	case s.released <- struct{}{}:
+	default:
+	}
+}
+
+type hostQueue struct {
+	reqs    []base.Request
+	running bool
+	slot    *politeSlot
+}
+
+type myPoliteness struct {
+	args        PolitenessArgs
+	hosts       map[string]*hostQueue
+	ipSlots     map[string]*politeSlot
+	crawlDelays map[string]time.Duration
+	pending     uint64
+	dropped     uint64
+	closed      chan struct{}
+	closeOnce   sync.Once
+	workers     sync.WaitGroup
+	mutex       sync.Mutex
+}
+
+func NewPoliteness(args PolitenessArgs) Politeness {
+	return &myPoliteness{
+		args:        args,
+		hosts:       make(map[string]*hostQueue),
+		ipSlots:     make(map[string]*politeSlot),
+		crawlDelays: make(map[string]time.Duration),
+		closed:      make(chan struct{}),
+	}
+}
+
+func hostOfRequest(req base.Request) string {
+	if !req.Valid() {
+		return ""
+	}
+	return strings.ToLower(req.Get().URL.Hostname())
+}
+
+func (m *myPoliteness) Submit(req base.Request, handle politeHandler) {
+	if handle == nil {
+		panic(errors.New("The politeness handler is nil!"))
+	}
+	host := hostOfRequest(req)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	select {
+	case <-m.closed:
+		atomic.AddUint64(&m.dropped, 1)
+		return
+	default:
+	}
+	queue, ok := m.hosts[host]
+	if !ok {
+		queue = &hostQueue{slot: newPoliteSlot()}
+		m.hosts[host] = queue
+	}
+	queue.reqs = append(queue.reqs, req)
+	atomic.AddUint64(&m.pending, 1)
+	if !queue.running {
+		queue.running = true
+		m.workers.Add(1)
+		go m.work(host, queue, handle)
+	}
+}
+
+// the worker of a host exits once its queue is empty and is restarted by Submit
+func (m *myPoliteness) work(host string, queue *hostQueue, handle politeHandler) {
+	defer m.workers.Done()
+	var ipSlot *politeSlot
+	if m.args.PerIp {
+		ipSlot = m.ipSlot(host)
+	}
+	for {
+		m.mutex.Lock()
+		if len(queue.reqs) == 0 {
+			queue.running = false
+			m.mutex.Unlock()
+			return
+		}
+		req := queue.reqs[0]
+		queue.reqs[0] = base.Request{}
+		queue.reqs = queue.reqs[1:]
+		delay, maxConcurrency := m.limitsOf(host)
+		m.mutex.Unlock()
+
+		if !queue.slot.acquire(m.closed, delay, maxConcurrency) {
+			atomic.AddUint64(&m.pending, ^uint64(0))
+			atomic.AddUint64(&m.dropped, 1)
+			return
+		}
+		if ipSlot != nil && !ipSlot.acquire(m.closed, delay, maxConcurrency) {
+			queue.slot.release()
+			atomic.AddUint64(&m.pending, ^uint64(0))
+			atomic.AddUint64(&m.dropped, 1)
+			return
+		}
+		var once sync.Once
+		done := func() {
+			once.Do(func() {
+				if ipSlot != nil {
+					ipSlot.release()
+				}
+				queue.slot.release()
+				atomic.AddUint64(&m.pending, ^uint64(0))
+			})
+		}
+		handle(req, done)
+	}
+}
+
+// must be called with the mutex locked
+func (m *myPoliteness) limitsOf(host string) (time.Duration, uint32) {
+	delay := m.args.MinDelay
+	if m.args.HonorCrawlDelay {
+		if crawlDelay := m.crawlDelays[host]; crawlDelay > delay {
+			delay = crawlDelay
+		}
+	}
+	return delay, m.args.MaxConcurrency
+}
+
+// hosts which can not be resolved share the slot named by the host itself
+func (m *myPoliteness) ipSlot(host string) *politeSlot {
+	key := host
+	ctx, cancel := context.WithTimeout(context.Background(), politeResolveTimeout)
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	cancel()
+	if err == nil && len(addrs) > 0 {
+		key = addrs[0].IP.String()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	slot, ok := m.ipSlots[key]
+	if !ok {
+		slot = newPoliteSlot()
+		m.ipSlots[key] = slot
+	}
+	return slot
+}
+
+func (m *myPoliteness) SetCrawlDelay(host string, delay time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.crawlDelays[strings.ToLower(host)] = delay
+}
+
+func (m *myPoliteness) SetLimits(minDelay time.Duration, maxConcurrency uint32) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.args.MinDelay = minDelay
+	m.args.MaxConcurrency = maxConcurrency
+}
+
+func (m *myPoliteness) Limits() (time.Duration, uint32) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.args.MinDelay, m.args.MaxConcurrency
+}
+
+func (m *myPoliteness) Pending() uint64 {
+	return atomic.LoadUint64(&m.pending)
+}
+
+func (m *myPoliteness) Close() {
+	m.closeOnce.Do(func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		close(m.closed)
+		for _, queue := range m.hosts {
+			if dropped := len(queue.reqs); dropped > 0 {
+				atomic.AddUint64(&m.pending, ^uint64(dropped-1))
+				atomic.AddUint64(&m.dropped, uint64(dropped))
+			}
+			queue.reqs = nil
+		}
+	})
+	//the workers can't be started once closed, they exit after the handler being called
+	m.workers.Wait()
+}
+
+func (m *myPoliteness) Dropped() uint64 {
+	return atomic.LoadUint64(&m.dropped)
+}
+
+func (m *myPoliteness) Summary() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	summaryTemplate := "hosts: %d, pending: %d, dropped: %d, minDelay: %s, maxConcurrency: %d, perIp: %v, honorCrawlDelay: %v"
+	return fmt.Sprintf(summaryTemplate, len(m.hosts), m.Pending(), m.Dropped(),
+		m.args.MinDelay, m.args.MaxConcurrency, m.args.PerIp, m.args.HonorCrawlDelay)
+}
@@ -0,0 +1,468 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gocrawler/base"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// the sources of the records of the traffic outside the downloaders
+const (
+	ARCHIVE_SOURCE_ROBOTS = "robots"
+	ARCHIVE_SOURCE_LOGIN  = "login"
+)
+
+// the modes of an archive in the summary
+const (
+	ARCHIVE_MODE_RECORD = "record"
+	ARCHIVE_MODE_REPLAY = "replay"
+)
+
+// the value the redacted query fields are recorded with
+const ARCHIVE_REDACTED_VALUE = "REDACTED"
+
+// archiveRecord is a download in an archive, an archive file holds one record per line
+type archiveRecord struct {
+	//empty for the downloads, otherwise the round trip of a client, e.g. ARCHIVE_SOURCE_LOGIN
+	Source string `json:"source,omitempty"`
+	Method string `json:"method"`
+	Url    string `json:"url"`
+	Depth  uint32 `json:"depth"`
+	//the request header without the sensitive headers, e.g. Authorization and Cookie
+	RequestHeader http.Header `json:"request_header,omitempty"`
+	//the url of the last request if the request was redirected
+	FinalUrl   string      `json:"final_url,omitempty"`
+	Status     string      `json:"status,omitempty"`
+	StatusCode int         `json:"status_code,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+	//the error of the download, the response fields are empty then
+	Error     string `json:"error,omitempty"`
+	Retryable bool   `json:"retryable,omitempty"`
+}
+
+func (r *archiveRecord) key() string {
+	httpReq, err := http.NewRequest(r.Method, r.Url, nil)
+	if err != nil {
+		return ""
+	}
+	key := base.RequestKey(base.NewRequest(httpReq, r.Depth))
+	if r.Source != "" {
+		return r.Source + " " + key
+	}
+	return key
+}
+
+func (r *archiveRecord) response(req *http.Request) *http.Response {
+	httpRes := &http.Response{
+		Status:        r.Status,
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        r.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+	if httpRes.Header == nil {
+		httpRes.Header = make(http.Header)
+	}
+	return httpRes
+}
+
+// archiveRecorder appends the records to an archive file, it's shared by the downloaders
+type archiveRecorder struct {
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+	count  uint64
+	//whether the temporary file has replaced the archive
+	published bool
+	closed    bool
+	mutex     sync.Mutex
+}
+
+// newArchiveRecorder writes to a temporary file next to the archive, an existing archive
+// is only replaced once publish is called
+func newArchiveRecorder(path string) (*archiveRecorder, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &archiveRecorder{
+		path:   path,
+		file:   file,
+		writer: bufio.NewWriter(file),
+	}, nil
+}
+
+// publish moves the temporary file to the archive path, the records keep being appended to it
+func (r *archiveRecorder) publish() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return errors.New("The archive recorder is closed!")
+	}
+	if err := os.Rename(r.file.Name(), r.path); err != nil {
+		return err
+	}
+	r.published = true
+	return nil
+}
+
+// record writes a line and flushes it, so a crashed crawl leaves a usable archive.
+// The records after closing are dropped
+func (r *archiveRecorder) record(record *archiveRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil
+	}
+	if _, err := r.writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	r.count++
+	return r.writer.Flush()
+}
+
+// close removes the temporary file if it's never published
+func (r *archiveRecorder) close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if !r.published {
+		r.file.Close()
+		return os.Remove(r.file.Name())
+	}
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+func (r *archiveRecorder) summary() ArchiveSummary {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return ArchiveSummary{Mode: ARCHIVE_MODE_RECORD, Recorded: r.count}
+}
+
+type recordPageDownloader struct {
+	downloader PageDownloader
+	recorder   *archiveRecorder
+}
+
+func newRecordPageDownloader(downloader PageDownloader, recorder *archiveRecorder) PageDownloader {
+	return &recordPageDownloader{
+		downloader: downloader,
+		recorder:   recorder,
+	}
+}
+
+func (m *recordPageDownloader) Id() uint32 {
+	return m.downloader.Id()
+}
+
+func (m *recordPageDownloader) Download(req base.Request) (*base.Response, error) {
+	return m.DownloadContext(context.Background(), req)
+}
+
+// the body of the response is read into memory for the record and put back
+func (m *recordPageDownloader) DownloadContext(ctx context.Context, req base.Request) (*base.Response, error) {
+	res, err := m.downloader.DownloadContext(ctx, req)
+	if ctx.Err() != nil || !req.Valid() {
+		//the downloads aborted by stopping are not part of the crawl
+		return res, err
+	}
+	httpReq := req.Get()
+	record := &archiveRecord{
+		Method:        httpReq.Method,
+		Url:           httpReq.URL.String(),
+		Depth:         req.Depth(),
+		RequestHeader: withoutSensitiveHeaders(httpReq.Header),
+	}
+	if err != nil {
+		record.Error = err.Error()
+		record.Retryable = base.IsRetryable(err)
+	} else if res != nil && res.Get() != nil {
+		httpRes := res.Get()
+		if httpRes.Request != nil && httpRes.Request.URL.String() != record.Url {
+			record.FinalUrl = httpRes.Request.URL.String()
+		}
+		record.Status = httpRes.Status
+		record.StatusCode = httpRes.StatusCode
+		record.Header = httpRes.Header
+		if httpRes.Body != nil {
+			body, readErr := io.ReadAll(httpRes.Body)
+			httpRes.Body.Close()
+			httpRes.Body = io.NopCloser(bytes.NewReader(body))
+			if readErr != nil {
+				return res, readErr
+			}
+			record.Body = body
+		}
+	} else {
+		return res, err
+	}
+	if recordErr := m.recorder.record(record); recordErr != nil && err == nil {
+		errMsg := fmt.Sprintf("Failed to record the download: %s", recordErr)
+		return res, errors.New(errMsg)
+	}
+	return res, err
+}
+
+// replayArchive serves the records of an archive file. The records of a request are
+// served in the recorded order, the last one is repeated once they are used up
+type replayArchive struct {
+	records map[string][]*archiveRecord
+	next    map[string]int
+	served  uint64
+	missing uint64
+	mutex   sync.Mutex
+}
+
+func loadReplayArchive(path string) (*replayArchive, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	archive := &replayArchive{
+		records: make(map[string][]*archiveRecord),
+		next:    make(map[string]int),
+	}
+	reader := bufio.NewReader(file)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			record := &archiveRecord{}
+			if jsonErr := json.Unmarshal(line, record); jsonErr != nil {
+				errMsg := fmt.Sprintf("Invalid archive record! (line=%d, error=%s)", lineNumber, jsonErr)
+				return nil, errors.New(errMsg)
+			}
+			key := record.key()
+			archive.records[key] = append(archive.records[key], record)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return archive, nil
+}
+
+func (a *replayArchive) take(key string) *archiveRecord {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	records := a.records[key]
+	if len(records) == 0 {
+		a.missing++
+		return nil
+	}
+	index := a.next[key]
+	if index < len(records)-1 {
+		a.next[key] = index + 1
+	}
+	a.served++
+	return records[index]
+}
+
+func (a *replayArchive) summary() ArchiveSummary {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return ArchiveSummary{
+		Mode:     ARCHIVE_MODE_REPLAY,
+		Requests: len(a.records),
+		Served:   a.served,
+		Missing:  a.missing,
+	}
+}
+
+type replayPageDownloader struct {
+	id      uint32
+	archive *replayArchive
+}
+
+// NewReplayPageDownloader serves the requests from the archive recorded by WithRecording
+// instead of the network. A request not in the archive fails with a downloader error
+func NewReplayPageDownloader(archivePath string) (PageDownloader, error) {
+	archive, err := loadReplayArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	return newReplayPageDownloader(archive), nil
+}
+
+func newReplayPageDownloader(archive *replayArchive) PageDownloader {
+	return &replayPageDownloader{
+		id:      genDownloaderId(),
+		archive: archive,
+	}
+}
+
+func (m *replayPageDownloader) Id() uint32 {
+	return m.id
+}
+
+func (m *replayPageDownloader) Download(req base.Request) (*base.Response, error) {
+	return m.DownloadContext(context.Background(), req)
+}
+
+func (m *replayPageDownloader) DownloadContext(ctx context.Context, req base.Request) (*base.Response, error) {
+	if !req.Valid() {
+		return nil, errors.New("The request is invalid!")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	record := m.archive.take(base.RequestKey(&req))
+	if record == nil {
+		return nil, base.NewCrawlerErrorWithDetail(base.DOWNLOADER_ERROR,
+			"The request is not in the archive!", base.ErrorDetail{
+				Url:   req.Get().URL.String(),
+				Depth: req.Depth(),
+			})
+	}
+	if record.Error != "" {
+		return nil, base.NewCrawlerErrorWithDetail(base.DOWNLOADER_ERROR, record.Error, base.ErrorDetail{
+			Url:       req.Get().URL.String(),
+			Depth:     req.Depth(),
+			Retryable: record.Retryable,
+		})
+	}
+	httpReq := req.Get().Clone(ctx)
+	if record.FinalUrl != "" {
+		//the redirect is replayed as one hop, so the original request can still be found
+		finalReq := httpReq.Clone(ctx)
+		finalUrl, err := httpReq.URL.Parse(record.FinalUrl)
+		if err != nil {
+			return nil, err
+		}
+		finalReq.URL = finalUrl
+		finalReq.Host = finalUrl.Host
+		finalReq.Response = &http.Response{Request: httpReq}
+		httpReq = finalReq
+	}
+	res := base.NewResponse(record.response(httpReq), req.Depth())
+	attempt := req.Attempt()
+	if attempt == 0 {
+		attempt = 1
+	}
+	res.SetAttempt(attempt)
+	res.SetSession(req.Session())
+	return res, nil
+}
+
+// archiveTransport records the round trips of a client to the archive, or serves them from
+// the replay archive. It's used for the traffic outside the downloaders, e.g. robots.txt and
+// the login, each hop of a redirect is a record of its own
+type archiveTransport struct {
+	source    string
+	transport http.RoundTripper
+	recorder  *archiveRecorder
+	replay    *replayArchive
+	//the query fields whose values are redacted in the records, e.g. the credentials of
+	//a login form submitted by GET. The replayed requests are looked up redacted too
+	redacted []string
+}
+
+func newArchiveTransport(transport http.RoundTripper, source string,
+	recorder *archiveRecorder, replay *replayArchive) *archiveTransport {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &archiveTransport{
+		source:    source,
+		transport: transport,
+		recorder:  recorder,
+		replay:    replay,
+	}
+}
+
+func (t *archiveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	record := &archiveRecord{
+		Source: t.source,
+		Method: req.Method,
+		Url:    redactUrl(req.URL, t.redacted),
+	}
+	if t.replay != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		replayed := t.replay.take(record.key())
+		if replayed == nil {
+			errMsg := fmt.Sprintf("The request is not in the archive! (url=%s)", record.Url)
+			return nil, errors.New(errMsg)
+		}
+		if replayed.Error != "" {
+			return nil, errors.New(replayed.Error)
+		}
+		return replayed.response(req), nil
+	}
+	res, err := t.transport.RoundTrip(req)
+	if err != nil {
+		record.Error = err.Error()
+		record.Retryable = base.IsRetryable(err)
+	} else {
+		body, readErr := io.ReadAll(res.Body)
+		res.Body.Close()
+		if readErr != nil {
+			return nil, readErr
+		}
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		record.Status = res.Status
+		record.StatusCode = res.StatusCode
+		record.Header = res.Header
+		record.Body = body
+	}
+	if t.recorder != nil {
+		if recordErr := t.recorder.record(record); recordErr != nil && err == nil {
+			errMsg := fmt.Sprintf("Failed to record the round trip: %s", recordErr)
+			return nil, errors.New(errMsg)
+		}
+	}
+	return res, err
+}
+
+// redactUrl returns the url with the values of the query fields replaced by
+// ARCHIVE_REDACTED_VALUE, the url is kept as it is if it has none of the fields
+func redactUrl(u *url.URL, fields []string) string {
+	query := u.Query()
+	redacted := false
+	for _, name := range fields {
+		values, ok := query[name]
+		if !ok {
+			continue
+		}
+		for i := range values {
+			values[i] = ARCHIVE_REDACTED_VALUE
+		}
+		redacted = true
+	}
+	if !redacted {
+		return u.String()
+	}
+	redactedUrl := *u
+	redactedUrl.RawQuery = query.Encode()
+	return redactedUrl.String()
+}
@@ -0,0 +1,224 @@
+package crawler
+
+import (
+	"fmt"
+	"gocrawler/base"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestArchiveServer() *httptest.Server {
+	var visits int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html>page</html>")
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/visits", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "visit %d", atomic.AddInt32(&visits, 1))
+	})
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+	})
+	return httptest.NewServer(mux)
+}
+
+func readTestBody(t *testing.T, res *http.Response) string {
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestRecordReplay(t *testing.T) {
+	server := newTestArchiveServer()
+	path := filepath.Join(t.TempDir(), "archive.jsonl")
+	recorder, err := newArchiveRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.publish(); err != nil {
+		t.Fatal(err)
+	}
+	downloader := newRecordPageDownloader(NewPageDownloader(nil), recorder)
+	tests := []struct {
+		path       string
+		statusCode int
+		finalPath  string
+		body       string
+	}{
+		{"/page", http.StatusOK, "/page", "<html>page</html>"},
+		{"/redirect", http.StatusOK, "/page", "<html>page</html>"},
+		{"/missing", http.StatusNotFound, "/missing", "404 page not found\n"},
+		{"/visits", http.StatusOK, "/visits", "visit 1"},
+		{"/visits", http.StatusOK, "/visits", "visit 2"},
+	}
+	for _, test := range tests {
+		res, err := downloader.Download(*newTestRequest(t, server.URL+test.path, 1))
+		if err != nil {
+			t.Fatalf("record %s: %s", test.path, err)
+		}
+		if got := readTestBody(t, res.Get()); got != test.body {
+			t.Errorf("record %s: body = %q, want %q", test.path, got, test.body)
+		}
+	}
+	robotsClient := &http.Client{Transport: newArchiveTransport(nil, ARCHIVE_SOURCE_ROBOTS, recorder, nil)}
+	robotsRes, err := robotsClient.Get(server.URL + "/robots.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	robotsBody := readTestBody(t, robotsRes)
+	if err := recorder.close(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	replayer, err := NewReplayPageDownloader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the last record of a request is repeated once the records are used up
+	tests = append(tests, tests[len(tests)-1])
+	for _, test := range tests {
+		res, err := replayer.Download(*newTestRequest(t, server.URL+test.path, 1))
+		if err != nil {
+			t.Fatalf("replay %s: %s", test.path, err)
+		}
+		httpRes := res.Get()
+		if httpRes.StatusCode != test.statusCode {
+			t.Errorf("replay %s: status = %d, want %d", test.path, httpRes.StatusCode, test.statusCode)
+		}
+		if httpRes.Request.URL.Path != test.finalPath {
+			t.Errorf("replay %s: final path = %s, want %s", test.path, httpRes.Request.URL.Path, test.finalPath)
+		}
+		if got := readTestBody(t, httpRes); got != test.body {
+			t.Errorf("replay %s: body = %q, want %q", test.path, got, test.body)
+		}
+		if res.Depth() != 1 {
+			t.Errorf("replay %s: depth = %d, want 1", test.path, res.Depth())
+		}
+	}
+
+	_, err = replayer.Download(*newTestRequest(t, server.URL+"/unknown", 1))
+	if ce, ok := err.(base.CrawlerError); !ok || ce.Type() != base.DOWNLOADER_ERROR {
+		t.Errorf("replay of an unknown request error = %v, want a downloader error", err)
+	}
+
+	archive, err := loadReplayArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayClient := &http.Client{Transport: newArchiveTransport(nil, ARCHIVE_SOURCE_ROBOTS, nil, archive)}
+	replayedRobots, err := replayClient.Get(server.URL + "/robots.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestBody(t, replayedRobots); got != robotsBody {
+		t.Errorf("replayed robots.txt = %q, want %q", got, robotsBody)
+	}
+	//the robots.txt record is not served to the downloaders
+	if _, err := replayer.Download(*newTestRequest(t, server.URL+"/robots.txt", 1)); err == nil {
+		t.Errorf("replay of robots.txt by the downloader error = nil, want error")
+	}
+}
+
+func TestArchiveRecorderUnpublished(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.jsonl")
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	recorder, err := newArchiveRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.record(&archiveRecord{Method: "GET", Url: "http://example.com/"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.close(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "old\n" {
+		t.Errorf("archive content = %q, want the old archive kept", content)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("%d files in the archive directory, want the temporary file removed", len(entries))
+	}
+}
+
+func TestRecordRedaction(t *testing.T) {
+	server := newTestArchiveServer()
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "archive.jsonl")
+	recorder, err := newArchiveRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.publish(); err != nil {
+		t.Fatal(err)
+	}
+	downloader := newRecordPageDownloader(NewPageDownloader(nil), recorder)
+	req := newTestRequest(t, server.URL+"/page", 0)
+	req.Get().Header.Set("Authorization", "Bearer secret-token")
+	req.Get().Header.Set("Cookie", "session=secret-cookie")
+	req.Get().Header.Set("Accept", "text/html")
+	if _, err := downloader.Download(*req); err != nil {
+		t.Fatal(err)
+	}
+	loginTransport := newArchiveTransport(nil, ARCHIVE_SOURCE_LOGIN, recorder, nil)
+	loginTransport.redacted = []string{"user", "password"}
+	loginUrl := server.URL + "/page?next=%2Fhome&password=secret-password&user=alice"
+	loginClient := &http.Client{Transport: loginTransport}
+	if _, err := loginClient.Get(loginUrl); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret-token", "secret-cookie", "secret-password", "alice"} {
+		if strings.Contains(string(content), secret) {
+			t.Errorf("the archive holds %q", secret)
+		}
+	}
+	if !strings.Contains(string(content), "text/html") {
+		t.Errorf("the archive doesn't hold the other request headers")
+	}
+	//the login is replayed with the credentials of the config
+	archive, err := loadReplayArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayTransport := newArchiveTransport(nil, ARCHIVE_SOURCE_LOGIN, nil, archive)
+	replayTransport.redacted = []string{"user", "password"}
+	replayClient := &http.Client{Transport: replayTransport}
+	res, err := replayClient.Get(loginUrl)
+	if err != nil {
+		t.Fatalf("replay of the login: %s", err)
+	}
+	if got := readTestBody(t, res); got != "<html>page</html>" {
+		t.Errorf("replayed login body = %q, want the recorded page", got)
+	}
+}
@@ -0,0 +1,102 @@
+package crawler
+
+import (
+	"fmt"
+	"gocrawler/base"
+	"sync"
+)
+
+// requestCache buffers the requests which can not be put into request channel yet,
+// so that analyzers never block on a full request channel
+type requestCache interface {
+	//put a request into cache, return false if the cache is closed or the request is nil.
+	//The priority is only used by the caches ordered by a crawl strategy
+	put(req *base.Request, priority float64) bool
+	//get the first request in the cache, return nil if the cache is empty or closed
+	get() *base.Request
+	//get the capacity of cache
+	capacity() int
+	//get the number of requests in cache
+	length() int
+	//get the requests waiting in memory and the segment files holding the others, they are
+	//saved by checkpoints
+	snapshot() ([]*base.Request, []savedSegment)
+	//close the cache
+	close()
+	//get summary info
+	summary() string
+}
+
+type reqCacheBySlice struct {
+	cache  []*base.Request
+	mutex  sync.Mutex
+	status byte //0: running, 1: closed
+}
+
+var reqCacheStatusMap = map[byte]string{
+	0: "running",
+	1: "closed",
+}
+
+var reqCacheSummaryTemplate = "status: %s, length: %d, capacity: %d"
+
+func newRequestCache() requestCache {
+	return &reqCacheBySlice{
+		cache: make([]*base.Request, 0),
+	}
+}
+
+func (r *reqCacheBySlice) put(req *base.Request, priority float64) bool {
+	if req == nil {
+		return false
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.status == 1 {
+		return false
+	}
+	r.cache = append(r.cache, req)
+	return true
+}
+
+func (r *reqCacheBySlice) get() *base.Request {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.cache) == 0 || r.status == 1 {
+		return nil
+	}
+	req := r.cache[0]
+	r.cache[0] = nil
+	r.cache = r.cache[1:]
+	return req
+}
+
+func (r *reqCacheBySlice) capacity() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return cap(r.cache)
+}
+
+func (r *reqCacheBySlice) length() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.cache)
+}
+
+func (r *reqCacheBySlice) snapshot() ([]*base.Request, []savedSegment) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]*base.Request{}, r.cache...), nil
+}
+
+func (r *reqCacheBySlice) close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.status = 1
+}
+
+func (r *reqCacheBySlice) summary() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return fmt.Sprintf(reqCacheSummaryTemplate, reqCacheStatusMap[r.status], len(r.cache), cap(r.cache))
+}
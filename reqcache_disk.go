@@ -0,0 +1,295 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gocrawler/base"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var reqSegmentPattern = "seg-%08d.log"
+
+type reqSegment struct {
+	path  string
+	count int
+}
+
+// reqCacheByDisk keeps at most memoryLimit requests in memory, the others are appended to
+// segment files in dir. Once a request is spilled, the following requests are spilled too
+// until the segments are drained, so the requests are still got in the order they are put
+type reqCacheByDisk struct {
+	dir         string
+	memoryLimit int
+	memory      []*base.Request
+	//the segments from the oldest to the one being written
+	segments    []*reqSegment
+	diskCount   int
+	nextSegment int
+	writer      *bufio.Writer
+	file        *os.File
+	//whether the loaded segment files are kept until the checkpoint referring to them is
+	//replaced, they are removed at once otherwise
+	keepLoaded bool
+	//the loaded segment files the last snapshot still refers to
+	consumed []string
+	//the loaded segment files no snapshot refers to, removed by release
+	releasable []string
+	//the number of requests which couldn't be read back
+	dropped int
+	//report the requests which couldn't be read back, nil if they are only counted
+	reportError func(err error)
+	mutex       sync.Mutex
+	status      byte //0: running, 1: closed
+}
+
+// the segments of the checkpoint the crawl is resumed from are adopted in their order,
+// the other segments left by a previous crawl are removed
+func newDiskRequestCache(dir string, memoryLimit int, adopted []savedSegment, keepLoaded bool) (*reqCacheByDisk, error) {
+	if memoryLimit <= 0 {
+		return nil, errors.New("The memory limit of request cache should be positive!")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	r := &reqCacheByDisk{
+		dir:         dir,
+		memoryLimit: memoryLimit,
+		memory:      make([]*base.Request, 0),
+		keepLoaded:  keepLoaded,
+	}
+	kept := make(map[string]bool, len(adopted))
+	for _, saved := range adopted {
+		var index int
+		if _, err := fmt.Sscanf(saved.File, reqSegmentPattern, &index); err != nil {
+			errMsg := fmt.Sprintf("Invalid segment file in checkpoint! (file=%s)", saved.File)
+			return nil, errors.New(errMsg)
+		}
+		path := filepath.Join(dir, saved.File)
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		kept[path] = true
+		r.segments = append(r.segments, &reqSegment{path: path, count: saved.Count})
+		r.diskCount += saved.Count
+		if index >= r.nextSegment {
+			r.nextSegment = index + 1
+		}
+	}
+	stale, err := filepath.Glob(filepath.Join(dir, "seg-*.log"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range stale {
+		if kept[path] {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// the request is kept in memory if it can't be written to disk, it's never lost
+func (r *reqCacheByDisk) put(req *base.Request, priority float64) bool {
+	if req == nil {
+		return false
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.status == 1 {
+		return false
+	}
+	if r.diskCount == 0 && len(r.memory) < r.memoryLimit {
+		r.memory = append(r.memory, req)
+		return true
+	}
+	if err := r.spill(req); err != nil {
+		r.memory = append(r.memory, req)
+	}
+	return true
+}
+
+// spill appends the request to the segment being written, a new segment is started once
+// it holds memoryLimit requests, so one segment always fits in memory
+func (r *reqCacheByDisk) spill(req *base.Request) error {
+	if r.writer == nil || r.segments[len(r.segments)-1].count >= r.memoryLimit {
+		if err := r.closeWriter(); err != nil {
+			return err
+		}
+		path := filepath.Join(r.dir, fmt.Sprintf(reqSegmentPattern, r.nextSegment))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		r.nextSegment++
+		r.file = file
+		r.writer = bufio.NewWriter(file)
+		r.segments = append(r.segments, &reqSegment{path: path})
+	}
+	line, err := json.Marshal(newSavedRequest(req))
+	if err != nil {
+		return err
+	}
+	if _, err := r.writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	r.segments[len(r.segments)-1].count++
+	r.diskCount++
+	return nil
+}
+
+func (r *reqCacheByDisk) closeWriter() error {
+	if r.writer == nil {
+		return nil
+	}
+	err := r.writer.Flush()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	r.writer = nil
+	r.file = nil
+	return err
+}
+
+func (r *reqCacheByDisk) get() *base.Request {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.status == 1 {
+		return nil
+	}
+	if len(r.memory) == 0 && r.diskCount > 0 {
+		r.load()
+	}
+	if len(r.memory) == 0 {
+		return nil
+	}
+	req := r.memory[0]
+	r.memory[0] = nil
+	r.memory = r.memory[1:]
+	return req
+}
+
+// load moves the oldest segment into memory and removes its file unless the loaded
+// segments are kept. The requests which can't be read back are counted and reported
+func (r *reqCacheByDisk) load() {
+	segment := r.segments[0]
+	if len(r.segments) == 1 {
+		r.closeWriter()
+	}
+	r.segments = r.segments[1:]
+	r.diskCount -= segment.count
+	if r.keepLoaded {
+		r.consumed = append(r.consumed, segment.path)
+	} else {
+		defer os.Remove(segment.path)
+	}
+	file, err := os.Open(segment.path)
+	if err != nil {
+		r.drop(segment, segment.count, "", err)
+		return
+	}
+	defer file.Close()
+	//the lines are read whole, a request with a large body is never cut
+	reader := bufio.NewReader(file)
+	//an adopted segment may hold the requests written after its checkpoint, they are
+	//not part of it
+	for read := 0; read < segment.count; read++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			r.drop(segment, segment.count-read, "", err)
+			return
+		}
+		var saved savedRequest
+		if err := json.Unmarshal(line, &saved); err != nil {
+			r.drop(segment, 1, "", err)
+			continue
+		}
+		req, err := saved.request()
+		if err != nil {
+			r.drop(segment, 1, saved.Url, err)
+			continue
+		}
+		r.memory = append(r.memory, req)
+	}
+}
+
+// drop counts the requests of the segment which can't be read back and reports them, they
+// are lost since their urls are already seen
+func (r *reqCacheByDisk) drop(segment *reqSegment, count int, url string, cause error) {
+	r.dropped += count
+	if r.reportError == nil {
+		return
+	}
+	errMsg := fmt.Sprintf("Failed to read %d request(s) back from %s: %s",
+		count, filepath.Base(segment.path), cause)
+	r.reportError(base.NewCrawlerErrorWithDetail(base.SCHEDULER_ERROR, errMsg, base.ErrorDetail{
+		Cause: cause,
+		Url:   url,
+	}))
+}
+
+func (r *reqCacheByDisk) capacity() int {
+	return r.memoryLimit
+}
+
+func (r *reqCacheByDisk) length() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.memory) + r.diskCount
+}
+
+// snapshot flushes the segment being written, so the returned segments can be read back
+// as they are
+func (r *reqCacheByDisk) snapshot() ([]*base.Request, []savedSegment) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.writer != nil {
+		if r.writer.Flush() == nil {
+			r.file.Sync()
+		}
+	}
+	segments := make([]savedSegment, 0, len(r.segments))
+	for _, segment := range r.segments {
+		segments = append(segments, savedSegment{
+			File:  filepath.Base(segment.path),
+			Count: segment.count,
+		})
+	}
+	r.releasable = append(r.releasable, r.consumed...)
+	r.consumed = nil
+	return append([]*base.Request{}, r.memory...), segments
+}
+
+// release removes the loaded segment files the last saved snapshot doesn't refer to
+func (r *reqCacheByDisk) release() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, path := range r.releasable {
+		os.Remove(path)
+	}
+	r.releasable = nil
+}
+
+func (r *reqCacheByDisk) close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.status = 1
+	r.closeWriter()
+}
+
+func (r *reqCacheByDisk) summary() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	summaryTemplate := "status: %s, length: %d, memory: %d/%d, disk: %d, segments: %d, dropped: %d"
+	return fmt.Sprintf(summaryTemplate, reqCacheStatusMap[r.status], len(r.memory)+r.diskCount,
+		len(r.memory), r.memoryLimit, r.diskCount, len(r.segments), r.dropped)
+}
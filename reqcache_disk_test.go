@@ -0,0 +1,224 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"gocrawler/base"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// putTestRequests puts the requests of the urls /r0 to /r<count-1>
+func putTestRequests(t *testing.T, cache requestCache, count int) {
+	for i := 0; i < count; i++ {
+		if !cache.put(newTestRequest(t, fmt.Sprintf("http://example.com/r%d", i), 0), 0) {
+			t.Fatalf("put /r%d failed", i)
+		}
+	}
+}
+
+// getTestPaths gets the requests left in the cache and returns their paths
+func getTestPaths(cache requestCache) []string {
+	paths := make([]string, 0)
+	for req := cache.get(); req != nil; req = cache.get() {
+		paths = append(paths, req.Get().URL.Path)
+	}
+	return paths
+}
+
+func testPaths(from, to int) []string {
+	paths := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		paths = append(paths, fmt.Sprintf("/r%d", i))
+	}
+	return paths
+}
+
+func countSegmentFiles(t *testing.T, dir string) int {
+	files, err := filepath.Glob(filepath.Join(dir, "seg-*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestDiskRequestCacheOrder(t *testing.T) {
+	for _, count := range []int{0, 2, 3, 7, 20} {
+		dir := t.TempDir()
+		cache, err := newDiskRequestCache(dir, 3, nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		putTestRequests(t, cache, count)
+		if cache.length() != count {
+			t.Errorf("count %d: length() = %d, want %d", count, cache.length(), count)
+		}
+		got := strings.Join(getTestPaths(cache), " ")
+		if want := strings.Join(testPaths(0, count), " "); got != want {
+			t.Errorf("count %d: got %q, want %q", count, got, want)
+		}
+		if n := countSegmentFiles(t, dir); n != 0 {
+			t.Errorf("count %d: %d segment files left, want 0", count, n)
+		}
+		cache.close()
+	}
+}
+
+func TestDiskRequestCacheInterleaved(t *testing.T) {
+	cache, err := newDiskRequestCache(t.TempDir(), 2, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.close()
+	got := make([]string, 0)
+	next := 0
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 3; i++ {
+			cache.put(newTestRequest(t, fmt.Sprintf("http://example.com/r%d", next), 0), 0)
+			next++
+		}
+		for i := 0; i < 2; i++ {
+			got = append(got, cache.get().Get().URL.Path)
+		}
+	}
+	got = append(got, getTestPaths(cache)...)
+	if strings.Join(got, " ") != strings.Join(testPaths(0, next), " ") {
+		t.Errorf("got %v, want the order they were put", got)
+	}
+}
+
+func TestDiskRequestCacheAdopt(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := newDiskRequestCache(dir, 2, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putTestRequests(t, cache, 7)
+	//the first segment is loaded before the snapshot, its file is kept until released
+	cache.get()
+	cache.get()
+	cache.get()
+	memory, segments := cache.snapshot()
+	//the requests put after the snapshot are not part of it
+	cache.put(newTestRequest(t, "http://example.com/after", 0), 0)
+	cache.close()
+	if len(memory) != 1 || memory[0].Get().URL.Path != "/r3" {
+		t.Fatalf("snapshot memory = %v, want [/r3]", memory)
+	}
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf(reqSegmentPattern, 99)), []byte("{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := newDiskRequestCache(dir, 2, segments, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.close()
+	if n := countSegmentFiles(t, dir); n != len(segments) {
+		t.Errorf("%d segment files after adoption, want %d", n, len(segments))
+	}
+	got := strings.Join(getTestPaths(resumed), " ")
+	if want := strings.Join(testPaths(4, 7), " "); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if resumed.dropped != 0 {
+		t.Errorf("dropped = %d, want 0", resumed.dropped)
+	}
+	//the segments written after adoption don't reuse the adopted names
+	resumed.put(newTestRequest(t, "http://example.com/r7", 0), 0)
+	resumed.put(newTestRequest(t, "http://example.com/r8", 0), 0)
+	resumed.put(newTestRequest(t, "http://example.com/r9", 0), 0)
+	if got := strings.Join(getTestPaths(resumed), " "); got != "/r7 /r8 /r9" {
+		t.Errorf("got %q, want \"/r7 /r8 /r9\"", got)
+	}
+}
+
+func TestDiskRequestCacheAdoptMissingSegment(t *testing.T) {
+	segments := []savedSegment{{File: fmt.Sprintf(reqSegmentPattern, 0), Count: 2}}
+	if _, err := newDiskRequestCache(t.TempDir(), 2, segments, true); err == nil {
+		t.Error("newDiskRequestCache() = nil error, want the missing segment reported")
+	}
+}
+
+func TestDiskRequestCacheLongLine(t *testing.T) {
+	cache, err := newDiskRequestCache(t.TempDir(), 1, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.close()
+	longUrl := "http://example.com/long?q=" + strings.Repeat("x", 2*1024*1024)
+	putTestRequests(t, cache, 1)
+	cache.put(newTestRequest(t, longUrl, 0), 0)
+	cache.get()
+	req := cache.get()
+	if req == nil || req.Get().URL.String() != longUrl {
+		t.Errorf("the request with the long url isn't read back")
+	}
+	if cache.dropped != 0 {
+		t.Errorf("dropped = %d, want 0", cache.dropped)
+	}
+}
+
+func TestDiskRequestCacheReportDropped(t *testing.T) {
+	type entry struct {
+		name string
+		//the lines the spilled segment is replaced with
+		lines   string
+		want    []string
+		dropped int
+	}
+	entries := []entry{
+		{"corrupt line", `{"method":"GET","url":"http://example.com/r2"}` + "\n" + "{not json\n" +
+			`{"method":"GET","url":"http://example.com/r4"}` + "\n", []string{"/r2", "/r4"}, 1},
+		{"invalid request", `{"method":"GET","url":"http://example.com/r2"}` + "\n" +
+			`{"method":"GET","url":"://bad"}` + "\n" + `{"method":"GET","url":"http://example.com/r4"}` + "\n",
+			[]string{"/r2", "/r4"}, 1},
+		{"truncated", `{"method":"GET","url":"http://example.com/r2"}` + "\n", []string{"/r2"}, 2},
+		{"missing", "", []string{}, 3},
+	}
+	for _, e := range entries {
+		dir := t.TempDir()
+		cache, err := newDiskRequestCache(dir, 3, nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reported := make([]error, 0)
+		cache.reportError = func(err error) {
+			reported = append(reported, err)
+		}
+		putTestRequests(t, cache, 6)
+		cache.get()
+		cache.get()
+		cache.get()
+		//the segment of /r3 to /r5 is complete once the writer is flushed
+		cache.closeWriter()
+		path := filepath.Join(dir, fmt.Sprintf(reqSegmentPattern, 0))
+		if e.name == "missing" {
+			err = os.Remove(path)
+		} else {
+			err = os.WriteFile(path, []byte(e.lines), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := getTestPaths(cache)
+		if strings.Join(got, " ") != strings.Join(e.want, " ") {
+			t.Errorf("%s: got %v, want %v", e.name, got, e.want)
+		}
+		if cache.dropped != e.dropped {
+			t.Errorf("%s: dropped = %d, want %d", e.name, cache.dropped, e.dropped)
+		}
+		if len(reported) == 0 {
+			t.Errorf("%s: the dropped requests aren't reported", e.name)
+		}
+		for _, err := range reported {
+			var cError base.CrawlerError
+			if !errors.As(err, &cError) || cError.Type() != base.SCHEDULER_ERROR {
+				t.Errorf("%s: reported %v, want a scheduler error", e.name, err)
+			}
+		}
+		cache.close()
+	}
+}
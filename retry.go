@@ -0,0 +1,174 @@
+package crawler
+
+import (
+	"context"
+	"gocrawler/base"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// the number of bytes read from a response body before it's discarded for a retry
+var retryDrainLimit int64 = 64 * 1024
+
+type RetryPolicy struct {
+	//the max number of attempts of a request including the first one, 0 or 1 means no retry
+	MaxAttempts uint32
+	//the delay before the first retry, it doubles on each following retry
+	BaseDelay time.Duration
+	//the max delay between two attempts, 0 means unlimited. A Retry-After longer than
+	//this gives up retrying
+	MaxDelay time.Duration
+	//the fraction of the delay which is randomized, between 0 and 1
+	Jitter float64
+	//whether a response with the status code should be retried, 429 and 5xx by default
+	RetryStatus func(statusCode int) bool
+}
+
+func defaultRetryStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// backoff returns the delay before the next attempt after the given attempt failed
+func (p RetryPolicy) backoff(attempt uint32) time.Duration {
+	delay := p.BaseDelay
+	for i := uint32(1); i < attempt && (p.MaxDelay == 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 && delay > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay = time.Duration(float64(delay) * (1 - jitter + jitter*rand.Float64()))
+	}
+	return delay
+}
+
+// retryAfter parses the Retry-After header, which is either seconds or an http date
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+type retryPageDownloader struct {
+	downloader PageDownloader
+	policy     RetryPolicy
+}
+
+// NewRetryPageDownloader retries the requests which fail with retryable errors, e.g. network
+// errors and timeouts, or get 429 or 5xx by the policy. The id of the wrapped downloader is kept
+func NewRetryPageDownloader(downloader PageDownloader, policy RetryPolicy) PageDownloader {
+	if policy.RetryStatus == nil {
+		policy.RetryStatus = defaultRetryStatus
+	}
+	return &retryPageDownloader{
+		downloader: downloader,
+		policy:     policy,
+	}
+}
+
+func (m *retryPageDownloader) Id() uint32 {
+	return m.downloader.Id()
+}
+
+func (m *retryPageDownloader) Download(req base.Request) (*base.Response, error) {
+	return m.DownloadContext(context.Background(), req)
+}
+
+// the last response or error is returned once the attempts are used up
+func (m *retryPageDownloader) DownloadContext(ctx context.Context, req base.Request) (*base.Response, error) {
+	attempt := req.Attempt()
+	for {
+		attempt++
+		req.SetAttempt(attempt)
+		res, err := m.downloader.DownloadContext(ctx, req)
+		//the errors which can't be fixed by downloading again, e.g. a bad certificate or url,
+		//are returned at once
+		if ctx.Err() != nil || attempt >= m.policy.MaxAttempts || !canRetry(req) ||
+			(err != nil && !base.IsRetryable(err)) {
+			return res, attemptError(err, req)
+		}
+		delay := m.policy.backoff(attempt)
+		if err == nil {
+			if res == nil || res.Get() == nil || !m.policy.RetryStatus(res.Get().StatusCode) {
+				return res, err
+			}
+			if after, ok := retryAfter(res.Get()); ok {
+				if m.policy.MaxDelay > 0 && after > m.policy.MaxDelay {
+					return res, err
+				}
+				if after > delay {
+					delay = after
+				}
+			}
+			discardBody(res.Get())
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		if httpReq := req.Get(); httpReq.Body != nil && httpReq.Body != http.NoBody {
+			body, err := httpReq.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			retryReq := httpReq.Clone(httpReq.Context())
+			retryReq.Body = body
+			session := req.Session()
+			req = *base.NewRequest(retryReq, req.Depth())
+			req.SetAttempt(attempt)
+			req.SetSession(session)
+		}
+	}
+}
+
+// the error of the last attempt records the attempt number
+func attemptError(err error, req base.Request) error {
+	if err == nil {
+		return nil
+	}
+	return base.NewCrawlerErrorWithDetail(base.DOWNLOADER_ERROR, "", base.ErrorDetail{
+		Cause:     err,
+		Url:       req.Get().URL.String(),
+		Depth:     req.Depth(),
+		Attempt:   req.Attempt(),
+		Retryable: base.IsRetryable(err),
+	})
+}
+
+// a request with a body can only be retried if the body can be recreated
+func canRetry(req base.Request) bool {
+	httpReq := req.Get()
+	return httpReq.Body == nil || httpReq.Body == http.NoBody || httpReq.GetBody != nil
+}
+
+// drain a little of the body, so that the connection can be reused
+func discardBody(res *http.Response) {
+	if res.Body == nil {
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, retryDrainLimit))
+	res.Body.Close()
+}
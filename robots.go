@@ -0,0 +1,354 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"gocrawler/base"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// robots.txt larger than this is truncated as RFC 9309 allows
+var robotsMaxSize int64 = 500 * 1024
+
+var defaultRobotsTtl = 24 * time.Hour
+
+// unreachable robots.txt is retried sooner than a fetched one
+var defaultRobotsErrorTtl = 10 * time.Minute
+
+type RobotsArgs struct {
+	//the product token matched against the user-agent lines, e.g. "gocrawler"
+	UserAgent string
+	//how long a fetched robots.txt is cached, 24 hours by default
+	Ttl time.Duration
+	//how long an unreachable robots.txt is cached, 10 minutes by default
+	ErrorTtl time.Duration
+}
+
+// RobotsChecker fetches, caches and enforces the robots.txt of each host
+type RobotsChecker interface {
+	//whether the request is allowed by the robots.txt of its host, the robots.txt
+	//is fetched first if it's not cached or expired
+	Allowed(ctx context.Context, req base.Request) (bool, error)
+	//set the func which is called with the crawl delay each time a robots.txt is fetched
+	OnCrawlDelay(fn func(host string, delay time.Duration))
+	//get the number of hosts whose robots.txt is cached
+	Hosts() int
+	//get the summary info
+	Summary() string
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// robotsData is the parsed robots.txt of one host
+type robotsData struct {
+	groups []*robotsGroup
+	//allowAll is used when robots.txt is unavailable, disallowAll when it's unreachable
+	allowAll    bool
+	disallowAll bool
+}
+
+func parseRobots(r io.Reader) *robotsData {
+	data := &robotsData{}
+	var group *robotsGroup
+	//a user-agent line after any rule line starts a new group
+	inRules := false
+	scanner := bufio.NewScanner(io.LimitReader(r, robotsMaxSize))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+		switch key {
+		case "user-agent":
+			if group == nil || inRules {
+				group = &robotsGroup{}
+				data.groups = append(data.groups, group)
+				inRules = false
+			}
+			group.agents = append(group.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			if group == nil {
+				continue
+			}
+			inRules = true
+			if value == "" {
+				continue
+			}
+			group.rules = append(group.rules, robotsRule{allow: key == "allow", pattern: value})
+		case "crawl-delay":
+			if group == nil {
+				continue
+			}
+			inRules = true
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+				group.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+	return data
+}
+
+// the groups naming the user agent are merged, the "*" groups are used if none names it
+func (d *robotsData) groupsFor(userAgent string) []*robotsGroup {
+	token := strings.ToLower(userAgent)
+	var matched, defaults []*robotsGroup
+	for _, group := range d.groups {
+		for _, agent := range group.agents {
+			if agent == "*" {
+				defaults = append(defaults, group)
+				break
+			}
+			if i := strings.IndexAny(agent, "/ "); i >= 0 {
+				agent = agent[:i]
+			}
+			if token != "" && agent == token {
+				matched = append(matched, group)
+				break
+			}
+		}
+	}
+	if len(matched) > 0 {
+		return matched
+	}
+	return defaults
+}
+
+// the longest matching pattern decides, allow wins if an allow and a disallow pattern are equally long
+func (d *robotsData) allowed(userAgent string, u *url.URL) bool {
+	if d.allowAll {
+		return true
+	}
+	if d.disallowAll {
+		return false
+	}
+	target := u.EscapedPath()
+	if target == "" {
+		target = "/"
+	}
+	if target == "/robots.txt" {
+		return true
+	}
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+	allow := true
+	longest := -1
+	for _, group := range d.groupsFor(userAgent) {
+		for _, rule := range group.rules {
+			if !robotsMatch(rule.pattern, target) {
+				continue
+			}
+			if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+				longest = len(rule.pattern)
+				allow = rule.allow
+			}
+		}
+	}
+	return allow
+}
+
+func (d *robotsData) crawlDelay(userAgent string) time.Duration {
+	var delay time.Duration
+	for _, group := range d.groupsFor(userAgent) {
+		if group.crawlDelay > delay {
+			delay = group.crawlDelay
+		}
+	}
+	return delay
+}
+
+// robotsMatch matches the path against a pattern from its start, "*" matches any sequence
+// and a trailing "$" anchors the end of the path
+func robotsMatch(pattern string, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	if len(parts) == 1 {
+		return !anchored || pos == len(path)
+	}
+	for i, part := range parts[1:] {
+		last := i == len(parts)-2
+		if last && anchored {
+			return len(path)-pos >= len(part) && strings.HasSuffix(path, part)
+		}
+		j := strings.Index(path[pos:], part)
+		if j < 0 {
+			return false
+		}
+		pos += j + len(part)
+	}
+	return true
+}
+
+type robotsEntry struct {
+	data    *robotsData
+	expires time.Time
+	//closed when the robots.txt has been fetched
+	ready chan struct{}
+}
+
+type myRobotsChecker struct {
+	client       *http.Client
+	args         RobotsArgs
+	entries      map[string]*robotsEntry
+	onCrawlDelay func(host string, delay time.Duration)
+	mutex        sync.Mutex
+}
+
+func NewRobotsChecker(client *http.Client, args RobotsArgs) RobotsChecker {
+	if client == nil {
+		client = new(http.Client)
+	}
+	if args.Ttl == 0 {
+		args.Ttl = defaultRobotsTtl
+	}
+	if args.ErrorTtl == 0 {
+		args.ErrorTtl = defaultRobotsErrorTtl
+	}
+	return &myRobotsChecker{
+		client:  client,
+		args:    args,
+		entries: make(map[string]*robotsEntry),
+	}
+}
+
+func (m *myRobotsChecker) OnCrawlDelay(fn func(host string, delay time.Duration)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.onCrawlDelay = fn
+}
+
+func (m *myRobotsChecker) Allowed(ctx context.Context, req base.Request) (bool, error) {
+	if !req.Valid() {
+		return false, errors.New("The request is invalid!")
+	}
+	u := req.Get().URL
+	data, err := m.get(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	return data.allowed(m.args.UserAgent, u), nil
+}
+
+// only one robots.txt of an origin is fetched at a time, the others wait for it
+func (m *myRobotsChecker) get(ctx context.Context, u *url.URL) (*robotsData, error) {
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	m.mutex.Lock()
+	entry, ok := m.entries[origin]
+	if ok {
+		select {
+		case <-entry.ready:
+			if time.Now().After(entry.expires) {
+				ok = false
+			}
+		default:
+		}
+	}
+	var previous *robotsData
+	if !ok {
+		if entry != nil {
+			previous = entry.data
+		}
+		entry = &robotsEntry{ready: make(chan struct{})}
+		m.entries[origin] = entry
+		m.mutex.Unlock()
+		m.fetch(ctx, origin, u.Hostname(), entry, previous)
+	} else {
+		m.mutex.Unlock()
+	}
+	select {
+	case <-entry.ready:
+		return entry.data, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// per RFC 9309, a 4xx robots.txt means no restriction and an unreachable one means full
+// restriction. A previously fetched robots.txt is kept while the host is unreachable
+func (m *myRobotsChecker) fetch(ctx context.Context, origin string, host string, entry *robotsEntry, previous *robotsData) {
+	data, ttl := m.load(ctx, origin)
+	if data.disallowAll && previous != nil {
+		data = previous
+	}
+	m.mutex.Lock()
+	entry.data = data
+	entry.expires = time.Now().Add(ttl)
+	//a fetch aborted by the caller says nothing about the host, so it's not cached
+	if ctx.Err() != nil && m.entries[origin] == entry {
+		delete(m.entries, origin)
+	}
+	onCrawlDelay := m.onCrawlDelay
+	m.mutex.Unlock()
+	close(entry.ready)
+	if onCrawlDelay != nil {
+		onCrawlDelay(host, data.crawlDelay(m.args.UserAgent))
+	}
+}
+
+func (m *myRobotsChecker) load(ctx context.Context, origin string) (*robotsData, time.Duration) {
+	unreachable := &robotsData{disallowAll: true}
+	httpReq, err := http.NewRequest("GET", origin+"/robots.txt", nil)
+	if err != nil {
+		return unreachable, m.args.ErrorTtl
+	}
+	if m.args.UserAgent != "" {
+		httpReq.Header.Set("User-Agent", m.args.UserAgent)
+	}
+	res, err := m.client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return unreachable, m.args.ErrorTtl
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return parseRobots(res.Body), m.args.Ttl
+	case res.StatusCode == http.StatusTooManyRequests:
+		//most crawlers treat 429 like a server error rather than an unavailable robots.txt
+		return unreachable, m.args.ErrorTtl
+	case res.StatusCode >= 400 && res.StatusCode < 500:
+		return &robotsData{allowAll: true}, m.args.Ttl
+	}
+	return unreachable, m.args.ErrorTtl
+}
+
+func (m *myRobotsChecker) Hosts() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.entries)
+}
+
+func (m *myRobotsChecker) Summary() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return fmt.Sprintf("userAgent: %s, hosts: %d", m.args.UserAgent, len(m.entries))
+}
@@ -0,0 +1,121 @@
+package crawler
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRobotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/", "/", true},
+		{"/", "/any/page", true},
+		{"/private", "/private", true},
+		{"/private", "/private/page", true},
+		{"/private", "/privately", true},
+		{"/private", "/public", false},
+		{"/private/", "/private", false},
+		{"/*.php", "/index.php", true},
+		{"/*.php", "/dir/index.php?x=1", true},
+		{"/*.php", "/index.html", false},
+		{"/*.php$", "/index.php", true},
+		{"/*.php$", "/index.php?x=1", false},
+		{"/page$", "/page", true},
+		{"/page$", "/page/", false},
+		{"/a*b*c", "/a-b-c", true},
+		{"/a*b*c", "/a-c-b", false},
+		{"/a*b$", "/ab", true},
+		{"/a*b$", "/abb", true},
+		{"/a*b$", "/aba", false},
+		{"*", "/anything", true},
+		{"/*", "/anything", true},
+	}
+	for _, test := range tests {
+		if got := robotsMatch(test.pattern, test.path); got != test.want {
+			t.Errorf("robotsMatch(%q, %q) = %v, want %v", test.pattern, test.path, got, test.want)
+		}
+	}
+}
+
+func TestRobotsGroupsFor(t *testing.T) {
+	robots := `
+User-agent: *
+Disallow: /all
+Crawl-delay: 1
+
+User-agent: GoCrawler/1.0
+User-agent: other
+Disallow: /first
+
+User-agent: gocrawler
+Disallow: /second
+Crawl-delay: 2.5
+`
+	data := parseRobots(strings.NewReader(robots))
+	tests := []struct {
+		userAgent string
+		//the first disallowed pattern of each selected group
+		want []string
+	}{
+		{"gocrawler", []string{"/first", "/second"}},
+		{"GoCrawler", []string{"/first", "/second"}},
+		{"other", []string{"/first"}},
+		{"unknown", []string{"/all"}},
+		{"", []string{"/all"}},
+	}
+	for _, test := range tests {
+		groups := data.groupsFor(test.userAgent)
+		got := make([]string, 0, len(groups))
+		for _, group := range groups {
+			got = append(got, group.rules[0].pattern)
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("groupsFor(%q) = %v, want %v", test.userAgent, got, test.want)
+		}
+	}
+	if delay := data.crawlDelay("gocrawler"); delay.Seconds() != 2.5 {
+		t.Errorf("crawlDelay(gocrawler) = %s, want 2.5s", delay)
+	}
+}
+
+func TestRobotsAllowed(t *testing.T) {
+	robots := `
+User-agent: *
+Disallow: /private
+Allow: /private/public
+Disallow: /*.pdf$
+Disallow: /search?
+Allow: /same
+Disallow: /same
+`
+	data := parseRobots(strings.NewReader(robots))
+	tests := []struct {
+		rawUrl string
+		want   bool
+	}{
+		{"http://h/", true},
+		{"http://h", true},
+		{"http://h/private", false},
+		{"http://h/private/page", false},
+		{"http://h/private/public/page", true},
+		{"http://h/doc.pdf", false},
+		{"http://h/doc.pdf?download=1", true},
+		{"http://h/search", true},
+		{"http://h/search?q=go", false},
+		{"http://h/same", true},
+		{"http://h/robots.txt", true},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.rawUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := data.allowed("gocrawler", u); got != test.want {
+			t.Errorf("allowed(%q) = %v, want %v", test.rawUrl, got, test.want)
+		}
+	}
+}
@@ -1,18 +1,20 @@
 package crawler
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"gocrawler/base"
+	"gocrawler/middleware"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type GenHttpClient func() *http.Client
 
-type SchedSummary interface {
-	String() string
-	Detail() string
-	Same(other *SchedSummary) bool
-}
-
 type Scheduler interface {
 	//start scheduler
 	//create and init scheduler and each component, after that, scheduler will activate crawling process
@@ -27,14 +29,54 @@ type Scheduler interface {
 		poolSize uint32,
 		crawlDepth uint32,
 		httpClientGenerator GenHttpClient,
-		resParsers []parseResponse,
-		itemProcessors []base.ProcessItem,
+		resParsers []ParseResponse,
+		itemProcessors []ProcessItem,
+		firstHttpRequest base.Request,
+	) error
+	//same as Start, but the crawl is stopped once ctx is done. In-flight downloads are aborted,
+	//goroutines waiting on the pools are released and the remaining item processors are skipped
+	StartContext(ctx context.Context,
+		channelLen uint32,
+		poolSize uint32,
+		crawlDepth uint32,
+		httpClientGenerator GenHttpClient,
+		resParsers []ParseResponse,
+		itemProcessors []ProcessItem,
 		firstHttpRequest base.Request,
 	) error
+	//same as StartContext, but the crawl begins with all the seeds. The seeds are crawled
+	//at depth 0 and the duplicated ones are skipped
+	StartSeeds(ctx context.Context,
+		channelLen uint32,
+		poolSize uint32,
+		crawlDepth uint32,
+		httpClientGenerator GenHttpClient,
+		resParsers []ParseResponse,
+		itemProcessors []ProcessItem,
+		seeds []base.Request,
+	) error
+	//add a request to the running crawl as a new seed. It is checked like the links found
+	//in pages, and an error is returned if it's invalid, too deep, out of scope or seen before
+	AddRequest(req base.Request) error
+	//stop dispatching new downloads until Resume is called, the requests being downloaded,
+	//analyzed or processed are finished and the queued ones are kept. Return false if it's not running
+	Pause() bool
+	//continue the paused crawl, return false if it's not paused
+	Resume() bool
+	//change the politeness limits of the running crawl, an error is returned if politeness is not enabled
+	SetPolitenessLimits(minDelay time.Duration, maxConcurrency uint32) error
+	//get the politeness limits of the running crawl, an error is returned if politeness is not enabled
+	PolitenessLimits() (minDelay time.Duration, maxConcurrency uint32, err error)
 	// stop the crawling process and return if the stop process succeed
 	Stop() bool
-	//whether the scheduler is running
+	//stop taking requests from the queue and wait until the requests, responses and items
+	//already in the stages are finished or the timeout expires, then stop the crawling process.
+	//The queued requests are kept for the checkpoint. Return false if it's not running
+	StopDrain(timeout time.Duration) (DrainReport, bool)
+	//whether the scheduler is running, false if it's paused
 	Running() bool
+	//whether the scheduler is paused
+	Paused() bool
 	//get the error chan which stores the error in each component
 	ErrorChan() <-chan error
 	//justify whether each component is idle
@@ -42,3 +84,1417 @@ type Scheduler interface {
 	//get summary info
 	Summary(prefix string) SchedSummary
 }
+
+const (
+	SCHED_STATUS_UNSTARTED uint32 = 0
+	SCHED_STATUS_RUNNING   uint32 = 1
+	SCHED_STATUS_STOPPED   uint32 = 2
+	SCHED_STATUS_PAUSED    uint32 = 3
+	//the components are being built, the scheduler is running once they are all ready
+	SCHED_STATUS_STARTING uint32 = 4
+	//the components are being stopped, the scheduler is stopped once they are all closed
+	SCHED_STATUS_STOPPING uint32 = 5
+)
+
+var schedStatusNameMap = map[uint32]string{
+	SCHED_STATUS_UNSTARTED: "unstarted",
+	SCHED_STATUS_RUNNING:   "running",
+	SCHED_STATUS_STOPPED:   "stopped",
+	SCHED_STATUS_PAUSED:    "paused",
+	SCHED_STATUS_STARTING:  "starting",
+	SCHED_STATUS_STOPPING:  "stopping",
+}
+
+// the interval of moving requests from request cache to request channel
+var scheduleInterval = 10 * time.Millisecond
+
+type myScheduler struct {
+	channelLen   uint32
+	poolSize     uint32
+	crawlDepth   uint32
+	chanman      middleware.ChannelManager
+	stopSign     middleware.StopSign
+	dlpool       PageDownloaderPool
+	analyzerPool AnalyzerPool
+	itemPipeline ItemPipeline
+	//0: unstarted, 1: running, 2: stopped, 3: paused, 4: starting, 5: stopping
+	running uint32
+	//the goroutines of the current run, Start waits for the ones of the last run to exit
+	//before the components are replaced
+	workers  sync.WaitGroup
+	reqCache requestCache
+	//requests whose key has been seen are dropped
+	seenSet    middleware.SeenSet
+	duplicates uint64
+	//nil means the requests are downloaded without per-host throttling
+	politenessArgs *PolitenessArgs
+	politeness     Politeness
+	//nil means robots.txt is ignored
+	robotsArgs *RobotsArgs
+	robots     RobotsChecker
+	//nil means a failed request is never retried
+	retryPolicy *RetryPolicy
+	retries     uint64
+	//wrap the downloaders in order, outside the retries
+	dlMiddlewares []DownloaderMiddleware
+	//nil means the cookies are handled by the http clients
+	sessionArgs *SessionArgs
+	sessions    CookieSessions
+	//nil means the crawl needs no login
+	loginArgs *LoginArgs
+	logins    *loginManager
+	//nil means the responses are not cached
+	httpCacheArgs *HttpCacheArgs
+	httpCache     *httpCache
+	//the archive files the downloads are recorded to or replayed from
+	recordPath string
+	recorder   *archiveRecorder
+	replayPath string
+	replay     *replayArchive
+	//the requests taken from the cache and not finished yet, they are only tracked for
+	//checkpoints, nil means the crawl is not checkpointed
+	outstanding        *outstandingRequests
+	checkpointDir      string
+	checkpointInterval time.Duration
+	//held while moving a request from the cache to outstanding and while adding a request to
+	//the seen set and the cache, so a checkpoint sees the frontier and the seen set agree
+	frontierLock sync.Mutex
+	//held while saving a checkpoint, the periodic and the final ones never overlap
+	checkpointLock sync.Mutex
+	//the requests beyond the memory limit are spilled to the dir if it's not empty
+	queueDir         string
+	queueMemoryLimit int
+	//nil means the requests are downloaded in the order they are found
+	strategy CrawlStrategy
+	//nil means every found request within the crawl depth is crawled
+	scopeArgs *ScopeArgs
+	scope     Scope
+	//ctx is done when the scheduler is stopped
+	ctx    context.Context
+	cancel context.CancelFunc
+	//held for reading while sending to the channels, Stop holds it for writing to close them
+	sendLock sync.RWMutex
+	//closed by Resume or Stop, nil if the scheduler is not paused
+	resumeChan chan struct{}
+	pauseLock  sync.Mutex
+	//the requests in dispatch which haven't got a downloader yet, e.g. the ones waiting
+	//for Resume, they are still work of the downloader stage
+	dispatching uint64
+	//1 while StopDrain is waiting for the stages
+	draining      uint32
+	drainRejected uint64
+	//the counts of the responses downloaded and analyzed successfully, and the items which
+	//went through the item pipeline, each item is counted once
+	downloaded     uint64
+	analyzed       uint64
+	itemsProcessed uint64
+	//nil means no metrics are recorded
+	metrics *schedMetrics
+	//the *schedComponents of the last started run, read by the metrics, the summaries and
+	//the admin api
+	components atomic.Value
+	//the time of the last stop, it's used to compute the uptime
+	stopTime atomic.Value
+}
+
+// schedComponents are the components of a run. They are published once the run is started
+// and never changed, so they can be read while the scheduler is started again
+type schedComponents struct {
+	channelLen   uint32
+	poolSize     uint32
+	crawlDepth   uint32
+	startTime    time.Time
+	chanman      middleware.ChannelManager
+	stopSign     middleware.StopSign
+	dlpool       PageDownloaderPool
+	analyzerPool AnalyzerPool
+	itemPipeline ItemPipeline
+	reqCache     requestCache
+	seenSet      middleware.SeenSet
+	//the optional components are nil if they are not enabled
+	scope           Scope
+	politeness      Politeness
+	robots          RobotsChecker
+	robotsUserAgent string
+	httpCache       *httpCache
+	recorder        *archiveRecorder
+	replay          *replayArchive
+}
+
+// the components of the last started run, nil if the scheduler has never been started
+func (m *myScheduler) loadComponents() *schedComponents {
+	components, _ := m.components.Load().(*schedComponents)
+	return components
+}
+
+// SchedOption customizes the scheduler created by NewScheduler
+type SchedOption func(sched *myScheduler)
+
+// WithSeenSet replaces the default in-memory seen set, e.g. with a bloom filter for large crawls.
+// It's reset at Start unless the crawl is resumed from a checkpoint
+func WithSeenSet(seenSet middleware.SeenSet) SchedOption {
+	return func(sched *myScheduler) {
+		sched.seenSet = seenSet
+	}
+}
+
+// WithPoliteness throttles the requests to each host before they are downloaded
+func WithPoliteness(args PolitenessArgs) SchedOption {
+	return func(sched *myScheduler) {
+		sched.politenessArgs = &args
+	}
+}
+
+// WithRobots rejects the requests disallowed by the robots.txt of their hosts, the crawl delay
+// in robots.txt is honored if politeness is enabled with HonorCrawlDelay
+func WithRobots(args RobotsArgs) SchedOption {
+	return func(sched *myScheduler) {
+		sched.robotsArgs = &args
+	}
+}
+
+// WithRetryPolicy retries the failed downloads by the policy
+func WithRetryPolicy(policy RetryPolicy) SchedOption {
+	return func(sched *myScheduler) {
+		sched.retryPolicy = &policy
+	}
+}
+
+// WithDownloaderMiddlewares intercepts the downloads with the middlewares, a request is passed
+// to them once no matter how many times it's retried
+func WithDownloaderMiddlewares(middlewares ...DownloaderMiddleware) SchedOption {
+	return func(sched *myScheduler) {
+		sched.dlMiddlewares = append(sched.dlMiddlewares, middlewares...)
+	}
+}
+
+// WithSessions shares the cookies among the downloaders, the jars of the http clients
+// are not used then
+func WithSessions(args SessionArgs) SchedOption {
+	return func(sched *myScheduler) {
+		sched.sessionArgs = &args
+	}
+}
+
+// WithLogin logs each session in with the login form before its requests are downloaded,
+// and again when a response means the session expired. The sessions are shared if
+// WithSessions is not given
+func WithLogin(args LoginArgs) SchedOption {
+	return func(sched *myScheduler) {
+		sched.loginArgs = &args
+	}
+}
+
+// WithHttpCache stores the responses on disk and serves or revalidates them on later crawls
+func WithHttpCache(args HttpCacheArgs) SchedOption {
+	return func(sched *myScheduler) {
+		sched.httpCacheArgs = &args
+	}
+}
+
+// WithRecording records every download of the crawl to the archive file, together with the
+// robots.txt and the login traffic. The file is replaced once Start succeeds. The responses
+// served by the http cache are not recorded
+func WithRecording(archivePath string) SchedOption {
+	return func(sched *myScheduler) {
+		sched.recordPath = archivePath
+	}
+}
+
+// WithReplay downloads the requests from the archive file recorded by WithRecording instead of
+// the network. The robots.txt and the login traffic are served from the archive too
+func WithReplay(archivePath string) SchedOption {
+	return func(sched *myScheduler) {
+		sched.replayPath = archivePath
+	}
+}
+
+// WithCheckpoint saves the frontier, the seen set and the counters to dir periodically and
+// when the scheduler is stopped. Start resumes from the checkpoint in dir if there is one
+func WithCheckpoint(dir string, interval time.Duration) SchedOption {
+	return func(sched *myScheduler) {
+		sched.checkpointDir = dir
+		sched.checkpointInterval = interval
+	}
+}
+
+// WithDiskQueue keeps at most memoryLimit waiting requests in memory and spills the others
+// to segment files in dir, so the frontier can grow without exhausting the memory
+func WithDiskQueue(dir string, memoryLimit int) SchedOption {
+	return func(sched *myScheduler) {
+		sched.queueDir = dir
+		sched.queueMemoryLimit = memoryLimit
+	}
+}
+
+// WithCrawlStrategy orders the waiting requests by the strategy, it can't be used with a disk queue
+func WithCrawlStrategy(strategy CrawlStrategy) SchedOption {
+	return func(sched *myScheduler) {
+		sched.strategy = strategy
+	}
+}
+
+// WithScope drops the found requests out of the scope, the seeds are always crawled
+func WithScope(args ScopeArgs) SchedOption {
+	return func(sched *myScheduler) {
+		sched.scopeArgs = &args
+	}
+}
+
+func NewScheduler(options ...SchedOption) Scheduler {
+	sched := &myScheduler{}
+	for _, option := range options {
+		option(sched)
+	}
+	return sched
+}
+
+func (m *myScheduler) Start(channelLen uint32,
+	poolSize uint32,
+	crawlDepth uint32,
+	httpClientGenerator GenHttpClient,
+	resParsers []ParseResponse,
+	itemProcessors []ProcessItem,
+	firstHttpRequest base.Request,
+) error {
+	return m.StartContext(context.Background(), channelLen, poolSize, crawlDepth,
+		httpClientGenerator, resParsers, itemProcessors, firstHttpRequest)
+}
+
+func (m *myScheduler) StartContext(ctx context.Context,
+	channelLen uint32,
+	poolSize uint32,
+	crawlDepth uint32,
+	httpClientGenerator GenHttpClient,
+	resParsers []ParseResponse,
+	itemProcessors []ProcessItem,
+	firstHttpRequest base.Request,
+) error {
+	if !firstHttpRequest.Valid() {
+		return errors.New("The first http request is invalid!")
+	}
+	return m.StartSeeds(ctx, channelLen, poolSize, crawlDepth,
+		httpClientGenerator, resParsers, itemProcessors, []base.Request{firstHttpRequest})
+}
+
+func (m *myScheduler) StartSeeds(ctx context.Context,
+	channelLen uint32,
+	poolSize uint32,
+	crawlDepth uint32,
+	httpClientGenerator GenHttpClient,
+	resParsers []ParseResponse,
+	itemProcessors []ProcessItem,
+	seeds []base.Request,
+) (err error) {
+	//the start is claimed, so a concurrent start or stop can't see the components half built
+	status := atomic.LoadUint32(&m.running)
+	if status == SCHED_STATUS_STOPPING {
+		return errors.New("The scheduler is stopping!")
+	}
+	if status != SCHED_STATUS_UNSTARTED && status != SCHED_STATUS_STOPPED ||
+		!atomic.CompareAndSwapUint32(&m.running, status, SCHED_STATUS_STARTING) {
+		return errors.New("The scheduler has been started!")
+	}
+	//the goroutines of the last run still use the components, they exit soon since it's stopped
+	m.workers.Wait()
+	m.chanman = nil
+	m.reqCache = nil
+	m.politeness = nil
+	m.recorder = nil
+	m.cancel = nil
+	//a failed start releases what it has built and gets back to the previous status
+	defer func() {
+		if err != nil {
+			m.abortStart()
+			atomic.StoreUint32(&m.running, status)
+		}
+	}()
+	defer func() {
+		if p := recover(); p != nil {
+			errMsg := fmt.Sprintf("Fatal scheduler error: %s", p)
+			err = errors.New(errMsg)
+		}
+	}()
+	if ctx == nil {
+		return errors.New("The context is nil!")
+	}
+	if channelLen == 0 {
+		return errors.New("The channel length can not be 0!")
+	}
+	if poolSize == 0 {
+		return errors.New("The pool size can not be 0!")
+	}
+	if httpClientGenerator == nil {
+		return errors.New("The http client generator is nil!")
+	}
+	if resParsers == nil {
+		return errors.New("The response parser list is nil!")
+	}
+	if itemProcessors == nil {
+		return errors.New("The item processor list is nil!")
+	}
+	if len(seeds) == 0 {
+		return errors.New("The seed list is empty!")
+	}
+	for i, seed := range seeds {
+		if !seed.Valid() {
+			errMsg := fmt.Sprintf("The seed is invalid! Index: %d", i)
+			return errors.New(errMsg)
+		}
+	}
+	if m.strategy != nil && m.queueDir != "" {
+		return errors.New("The crawl strategy can't be used with a disk queue!")
+	}
+	if m.loginArgs != nil && m.sessionArgs == nil {
+		m.sessionArgs = &SessionArgs{}
+	}
+	if m.sessionArgs != nil {
+		m.sessions = NewCookieSessions()
+		if m.sessionArgs.CookieFile != "" {
+			if err := loadCookieFile(m.sessions, m.sessionArgs.CookieFile); err != nil {
+				errMsg := fmt.Sprintf("Failed to load cookies: %s", err)
+				return errors.New(errMsg)
+			}
+		}
+	}
+	if m.recordPath != "" && m.replayPath != "" {
+		return errors.New("The recording can't be used with a replay!")
+	}
+	m.replay = nil
+	if m.replayPath != "" {
+		replay, err := loadReplayArchive(m.replayPath)
+		if err != nil {
+			errMsg := fmt.Sprintf("Failed to load the replay archive: %s", err)
+			return errors.New(errMsg)
+		}
+		m.replay = replay
+	}
+	m.httpCache = nil
+	if m.httpCacheArgs != nil {
+		httpCache, err := newHttpCache(*m.httpCacheArgs)
+		if err != nil {
+			errMsg := fmt.Sprintf("Failed to create http cache: %s", err)
+			return errors.New(errMsg)
+		}
+		httpCache.reportError = func(err error) {
+			m.sendError(err, DOWNLOADER_CODE)
+		}
+		m.httpCache = httpCache
+	}
+	//the archive is written to a temporary file until the start succeeds, so a failed start
+	//doesn't truncate it
+	if m.recordPath != "" {
+		recorder, err := newArchiveRecorder(m.recordPath)
+		if err != nil {
+			errMsg := fmt.Sprintf("Failed to create the recording archive: %s", err)
+			return errors.New(errMsg)
+		}
+		m.recorder = recorder
+	}
+	//the sessions of the seeds are logged in before anything is dispatched
+	m.logins = nil
+	if m.loginArgs != nil {
+		genLoginClient := func() *http.Client {
+			return m.archiveClient(httpClientGenerator(), ARCHIVE_SOURCE_LOGIN)
+		}
+		logins, err := newLoginManager(*m.loginArgs, genLoginClient, m.sessions)
+		if err != nil {
+			return err
+		}
+		for i := range seeds {
+			if _, err := logins.ensure(ctx, m.sessionOfSeed(&seeds[i])); err != nil {
+				return err
+			}
+		}
+		m.logins = logins
+	}
+	atomic.StoreUint32(&m.draining, 0)
+	atomic.StoreUint64(&m.drainRejected, 0)
+
+	m.channelLen = channelLen
+	m.poolSize = poolSize
+	m.crawlDepth = crawlDepth
+	m.chanman = middleware.NewChannelManager(channelLen, true)
+
+	dlpool, err := NewPageDownloaderPool(poolSize, func() PageDownloader {
+		var downloader PageDownloader
+		if m.replay != nil {
+			downloader = newReplayPageDownloader(m.replay)
+		} else if m.sessions != nil {
+			downloader = NewSessionPageDownloader(httpClientGenerator(), m.sessions)
+		} else {
+			downloader = NewPageDownloader(httpClientGenerator())
+		}
+		if m.recorder != nil {
+			downloader = newRecordPageDownloader(downloader, m.recorder)
+		}
+		if m.retryPolicy != nil {
+			downloader = NewRetryPageDownloader(downloader, *m.retryPolicy)
+		}
+		if m.logins != nil {
+			downloader = newLoginPageDownloader(downloader, m.logins)
+		}
+		if m.httpCache != nil {
+			downloader = newCachePageDownloader(downloader, m.httpCache)
+		}
+		if len(m.dlMiddlewares) > 0 {
+			downloader = NewMiddlewarePageDownloader(downloader, m.dlMiddlewares...)
+		}
+		return downloader
+	})
+	if err != nil {
+		errMsg := fmt.Sprintf("Failed to create downloader pool: %s", err)
+		panic(errors.New(errMsg))
+	}
+	m.dlpool = dlpool
+	analyzerPool, err := NewAnalyzerPool(poolSize, genAnalyzer)
+	if err != nil {
+		errMsg := fmt.Sprintf("Failed to create analyzer pool: %s", err)
+		panic(errors.New(errMsg))
+	}
+	m.analyzerPool = analyzerPool
+	m.itemPipeline = NewItemPipeline(itemProcessors)
+
+	if m.stopSign == nil {
+		m.stopSign = middleware.NewStopSign()
+	} else {
+		m.stopSign.Reset()
+	}
+	if m.seenSet == nil {
+		m.seenSet = middleware.NewMemorySeenSet()
+	}
+	var state *checkpointState
+	if m.checkpointDir != "" {
+		if state, err = loadCheckpoint(m.checkpointDir, m.seenSet); err != nil {
+			errMsg := fmt.Sprintf("Failed to resume from checkpoint: %s", err)
+			panic(errors.New(errMsg))
+		}
+	}
+	//a new crawl starts with nothing seen, even if the scheduler has been started before
+	if state == nil {
+		m.seenSet.Reset()
+	}
+	if m.queueDir != "" {
+		var segments []savedSegment
+		if state != nil {
+			segments = state.segments
+		}
+		reqCache, err := newDiskRequestCache(m.queueDir, m.queueMemoryLimit, segments, m.checkpointDir != "")
+		if err != nil {
+			errMsg := fmt.Sprintf("Failed to create request cache: %s", err)
+			panic(errors.New(errMsg))
+		}
+		reqCache.reportError = func(err error) {
+			m.sendError(err, SCHEDULER_CODE)
+		}
+		m.reqCache = reqCache
+	} else if m.strategy != nil {
+		m.reqCache = newPriorityRequestCache()
+	} else {
+		m.reqCache = newRequestCache()
+	}
+	m.outstanding = nil
+	if m.checkpointDir != "" {
+		m.outstanding = newOutstandingRequests()
+	}
+	if m.politenessArgs != nil {
+		m.politeness = NewPoliteness(*m.politenessArgs)
+	}
+	if m.scopeArgs != nil {
+		scope, err := NewScope(*m.scopeArgs)
+		if err != nil {
+			errMsg := fmt.Sprintf("Failed to create scope: %s", err)
+			panic(errors.New(errMsg))
+		}
+		m.scope = scope
+		for _, seed := range seeds {
+			m.scope.AddSeed(seed.Get().URL)
+		}
+	}
+	//the counters are restored into the components built above
+	resumed := state != nil
+	if resumed {
+		m.resume(state)
+	}
+	if m.robotsArgs != nil {
+		robotsClient := m.archiveClient(httpClientGenerator(), ARCHIVE_SOURCE_ROBOTS)
+		m.robots = NewRobotsChecker(robotsClient, *m.robotsArgs)
+		if m.politeness != nil {
+			m.robots.OnCrawlDelay(m.politeness.SetCrawlDelay)
+		}
+	}
+	m.ctx, m.cancel = context.WithCancel(ctx)
+
+	m.startDownloading()
+	m.activateAnalyzers(resParsers)
+	m.openItemPipeline()
+	m.schedule(scheduleInterval)
+
+	//the seeds are always the roots of the crawl, a seed is only skipped if a resumed crawl
+	//has seen it or it's the duplicate of another seed
+	seedKeys := make(map[string]bool, len(seeds))
+	for _, seed := range seeds {
+		seedReq := base.NewRequest(seed.Get(), 0)
+		seedReq.SetSession(m.sessionOfSeed(&seed))
+		key := base.RequestKey(seedReq)
+		if seedKeys[key] {
+			continue
+		}
+		seedKeys[key] = true
+		if m.seenSet.Add(key) || !resumed {
+			m.reqCache.put(seedReq, m.priorityOf(seedReq, nil))
+		}
+	}
+	if m.checkpointDir != "" && m.checkpointInterval > 0 {
+		m.startCheckpointing(m.checkpointInterval)
+	}
+	if m.recorder != nil {
+		if err := m.recorder.publish(); err != nil {
+			errMsg := fmt.Sprintf("Failed to create the recording archive: %s", err)
+			return errors.New(errMsg)
+		}
+	}
+	components := &schedComponents{
+		channelLen:   channelLen,
+		poolSize:     poolSize,
+		crawlDepth:   crawlDepth,
+		startTime:    time.Now(),
+		chanman:      m.chanman,
+		stopSign:     m.stopSign,
+		dlpool:       m.dlpool,
+		analyzerPool: m.analyzerPool,
+		itemPipeline: m.itemPipeline,
+		reqCache:     m.reqCache,
+		seenSet:      m.seenSet,
+		scope:        m.scope,
+		politeness:   m.politeness,
+		httpCache:    m.httpCache,
+		recorder:     m.recorder,
+		replay:       m.replay,
+	}
+	if m.robotsArgs != nil {
+		components.robots = m.robots
+		components.robotsUserAgent = m.robotsArgs.UserAgent
+	}
+	m.components.Store(components)
+	atomic.StoreUint32(&m.running, SCHED_STATUS_RUNNING)
+	//the watcher of this run exits once it's stopped, so it can never stop a later run
+	runCtx := m.ctx
+	m.spawn(func() {
+		<-runCtx.Done()
+		m.Stop()
+	})
+	return nil
+}
+
+// spawn runs f in a goroutine of the current run
+func (m *myScheduler) spawn(f func()) {
+	m.workers.Add(1)
+	go func() {
+		defer m.workers.Done()
+		f()
+	}()
+}
+
+// archiveClient routes the round trips of the client through the archive when the crawl
+// is recorded or replayed, the client is returned as is otherwise
+func (m *myScheduler) archiveClient(client *http.Client, source string) *http.Client {
+	if m.recorder == nil && m.replay == nil {
+		return client
+	}
+	if client == nil {
+		client = new(http.Client)
+	}
+	archived := *client
+	transport := newArchiveTransport(client.Transport, source, m.recorder, m.replay)
+	//the credentials of a login form submitted by GET are in the query
+	if source == ARCHIVE_SOURCE_LOGIN && m.loginArgs != nil {
+		for name := range m.loginArgs.Fields {
+			transport.redacted = append(transport.redacted, name)
+		}
+	}
+	archived.Transport = transport
+	return &archived
+}
+
+// abortStart releases what a failed start has built, the goroutines started by then exit
+// once the stop sign is signed and the channels are closed
+func (m *myScheduler) abortStart() {
+	if m.stopSign != nil {
+		m.stopSign.Sign()
+	}
+	if m.cancel != nil {
+		m.cancel()
+	}
+	if m.chanman != nil {
+		m.sendLock.Lock()
+		m.chanman.Close()
+		m.sendLock.Unlock()
+	}
+	if m.reqCache != nil {
+		m.reqCache.close()
+	}
+	if m.politeness != nil {
+		m.politeness.Close()
+	}
+	if m.recorder != nil {
+		m.recorder.close()
+	}
+}
+
+// Stop doesn't wait for the goroutines of the crawl, they exit soon since ctx is done
+// and the channels are closed
+func (m *myScheduler) Stop() bool {
+	if !atomic.CompareAndSwapUint32(&m.running, SCHED_STATUS_RUNNING, SCHED_STATUS_STOPPING) &&
+		!atomic.CompareAndSwapUint32(&m.running, SCHED_STATUS_PAUSED, SCHED_STATUS_STOPPING) {
+		return false
+	}
+	m.stopTime.Store(time.Now())
+	m.stopSign.Sign()
+	//the blocked senders give up once ctx is done, so the lock can be taken
+	m.cancel()
+	//the next run must not start paused
+	m.pauseLock.Lock()
+	if m.resumeChan != nil {
+		close(m.resumeChan)
+		m.resumeChan = nil
+	}
+	m.pauseLock.Unlock()
+	m.sendLock.Lock()
+	m.chanman.Close()
+	m.sendLock.Unlock()
+	m.reqCache.close()
+	if m.politeness != nil {
+		m.politeness.Close()
+	}
+	if m.checkpointDir != "" {
+		//the error channel is closed, so the error of the last checkpoint can't be reported
+		m.checkpoint()
+	} else {
+		m.saveCookies()
+	}
+	if m.recorder != nil {
+		m.recorder.close()
+	}
+	atomic.StoreUint32(&m.running, SCHED_STATUS_STOPPED)
+	return true
+}
+
+func (m *myScheduler) Pause() bool {
+	m.pauseLock.Lock()
+	defer m.pauseLock.Unlock()
+	if !atomic.CompareAndSwapUint32(&m.running, SCHED_STATUS_RUNNING, SCHED_STATUS_PAUSED) {
+		return false
+	}
+	m.resumeChan = make(chan struct{})
+	return true
+}
+
+func (m *myScheduler) Resume() bool {
+	m.pauseLock.Lock()
+	defer m.pauseLock.Unlock()
+	if !atomic.CompareAndSwapUint32(&m.running, SCHED_STATUS_PAUSED, SCHED_STATUS_RUNNING) {
+		return false
+	}
+	close(m.resumeChan)
+	m.resumeChan = nil
+	return true
+}
+
+// the limits are changed on the politeness of the last started run
+func (m *myScheduler) SetPolitenessLimits(minDelay time.Duration, maxConcurrency uint32) error {
+	components := m.loadComponents()
+	if components == nil || components.politeness == nil {
+		return errors.New("The politeness is not enabled!")
+	}
+	if minDelay < 0 {
+		return errors.New("The min delay can not be negative!")
+	}
+	components.politeness.SetLimits(minDelay, maxConcurrency)
+	return nil
+}
+
+func (m *myScheduler) PolitenessLimits() (time.Duration, uint32, error) {
+	components := m.loadComponents()
+	if components == nil || components.politeness == nil {
+		return 0, 0, errors.New("The politeness is not enabled!")
+	}
+	minDelay, maxConcurrency := components.politeness.Limits()
+	return minDelay, maxConcurrency, nil
+}
+
+func (m *myScheduler) Paused() bool {
+	return atomic.LoadUint32(&m.running) == SCHED_STATUS_PAUSED
+}
+
+// waitResumed blocks while the scheduler is paused, false is returned if it's stopped meanwhile
+func (m *myScheduler) waitResumed() bool {
+	m.pauseLock.Lock()
+	resumeChan := m.resumeChan
+	m.pauseLock.Unlock()
+	if resumeChan == nil {
+		return true
+	}
+	select {
+	case <-resumeChan:
+		return true
+	case <-m.ctx.Done():
+		return false
+	}
+}
+
+func (m *myScheduler) AddRequest(req base.Request) error {
+	status := atomic.LoadUint32(&m.running)
+	if status != SCHED_STATUS_RUNNING && status != SCHED_STATUS_PAUSED {
+		return errors.New("The scheduler is not running!")
+	}
+	if atomic.LoadUint32(&m.draining) == 1 {
+		atomic.AddUint64(&m.drainRejected, 1)
+		return errors.New("The scheduler is draining!")
+	}
+	if !req.Valid() {
+		return errors.New("The request is invalid!")
+	}
+	req.SetSession(m.sessionOfSeed(&req))
+	if err := m.acceptRequest(req, nil); err != nil {
+		return err
+	}
+	//only an accepted seed widens the scope
+	if m.scope != nil {
+		m.scope.AddSeed(req.Get().URL)
+	}
+	return nil
+}
+
+func (m *myScheduler) Running() bool {
+	return atomic.LoadUint32(&m.running) == SCHED_STATUS_RUNNING
+}
+
+func (m *myScheduler) ErrorChan() <-chan error {
+	if m.chanman == nil {
+		return nil
+	}
+	errorChan, err := m.chanman.ErrorChan()
+	if err != nil {
+		return nil
+	}
+	return errorChan
+}
+
+// idle means no request is waiting or being downloaded, no response is waiting or being analyzed
+// and no item is waiting or being processed
+func (m *myScheduler) Idle() bool {
+	if m.chanman == nil {
+		return true
+	}
+	return m.reqCache.length() == 0 && m.stagesIdle()
+}
+
+// the stages are idle if nothing is waiting or being handled after leaving request cache
+func (m *myScheduler) stagesIdle() bool {
+	if m.dlpool.Used() != 0 || m.analyzerPool.Used() != 0 {
+		return false
+	}
+	if m.itemPipeline.ProcessingNumber() != 0 {
+		return false
+	}
+	if m.politeness != nil && m.politeness.Pending() != 0 {
+		return false
+	}
+	if atomic.LoadUint64(&m.dispatching) != 0 {
+		return false
+	}
+	return m.channelsEmpty()
+}
+
+func (m *myScheduler) Summary(prefix string) SchedSummary {
+	return NewSchedSummary(m, prefix)
+}
+
+func (m *myScheduler) channelsEmpty() bool {
+	if reqChan, err := m.chanman.ReqChan(); err == nil && len(reqChan) != 0 {
+		return false
+	}
+	if resChan, err := m.chanman.ResChan(); err == nil && len(resChan) != 0 {
+		return false
+	}
+	if itemChan, err := m.chanman.ItemChan(); err == nil && len(itemChan) != 0 {
+		return false
+	}
+	return true
+}
+
+// with politeness the requests are queued by host first, and dispatched once their host
+// can accept them
+func (m *myScheduler) startDownloading() {
+	reqChan := m.getReqChan()
+	m.spawn(func() {
+		for req := range reqChan {
+			if m.politeness != nil {
+				m.politeness.Submit(req, m.dispatch)
+				continue
+			}
+			m.dispatch(req, nil)
+		}
+	})
+}
+
+// take a downloader before the goroutine is spawned, so that the pool is never idle
+// while a request is on its way to be downloaded. done is called when the download finished
+func (m *myScheduler) dispatch(req base.Request, done func()) {
+	atomic.AddUint64(&m.dispatching, 1)
+	defer atomic.AddUint64(&m.dispatching, ^uint64(0))
+	//the request stays outstanding if the scheduler is stopped while paused
+	if !m.waitResumed() {
+		if done != nil {
+			done()
+		}
+		m.stopSign.Deal(DOWNLOADER_CODE)
+		return
+	}
+	downloader, err := m.dlpool.TakeContext(m.ctx)
+	if err != nil {
+		if done != nil {
+			done()
+		}
+		if m.ctx.Err() != nil {
+			m.stopSign.Deal(DOWNLOADER_CODE)
+			return
+		}
+		m.sendError(err, SCHEDULER_CODE)
+		return
+	}
+	m.spawn(func() {
+		m.download(downloader, req, done)
+	})
+}
+
+func (m *myScheduler) download(downloader PageDownloader, req base.Request, done func()) {
+	if done != nil {
+		defer done()
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			errMsg := fmt.Sprintf("Fatal download error: %s", p)
+			m.sendError(errors.New(errMsg), SCHEDULER_CODE)
+		}
+	}()
+	defer func() {
+		if err := m.dlpool.Return(downloader); err != nil {
+			m.sendError(err, SCHEDULER_CODE)
+		}
+	}()
+	code := generateCode(DOWNLOADER_CODE, downloader.Id())
+	if m.stopSign.Signed() {
+		m.stopSign.Deal(code)
+		return
+	}
+	if !m.allowedByRobots(req, code) {
+		m.finish(base.RequestKey(&req))
+		return
+	}
+	startTime := time.Now()
+	res, err := downloader.DownloadContext(m.ctx, req)
+	if m.metrics != nil {
+		m.metrics.observeDownload(res, time.Since(startTime))
+	}
+	if res == nil {
+		if m.ctx.Err() != nil {
+			//the download is aborted by stopping
+			m.stopSign.Deal(code)
+			return
+		}
+		m.finish(base.RequestKey(&req))
+	}
+	//the retries are counted by the last attempt, which the error carries if it failed
+	attempt := uint32(0)
+	var cError base.CrawlerError
+	if res != nil {
+		attempt = res.Attempt()
+	} else if errors.As(err, &cError) {
+		attempt = cError.Attempt()
+	}
+	if attempt > 1 {
+		atomic.AddUint64(&m.retries, uint64(attempt-1))
+	}
+	if res != nil {
+		if m.sendRes(*res, code) {
+			atomic.AddUint64(&m.downloaded, 1)
+		} else if httpRes := res.Get(); httpRes != nil && httpRes.Body != nil {
+			httpRes.Body.Close()
+		}
+	}
+	if err != nil {
+		m.sendRequestError(err, code, &req)
+	}
+}
+
+// disallowed requests are reported as robots errors instead of being dropped silently
+func (m *myScheduler) allowedByRobots(req base.Request, code string) bool {
+	if m.robots == nil {
+		return true
+	}
+	allowed, err := m.robots.Allowed(m.ctx, req)
+	if err != nil {
+		cError := base.NewCrawlerErrorWithDetail(base.ROBOTS_ERROR, "",
+			base.ErrorDetail{Cause: err, Retryable: true})
+		m.sendRequestError(cError, code, &req)
+		return false
+	}
+	if !allowed {
+		cError := base.NewCrawlerError(base.ROBOTS_ERROR, "The request is disallowed by robots.txt!")
+		m.sendRequestError(cError, code, &req)
+		return false
+	}
+	return true
+}
+
+func (m *myScheduler) activateAnalyzers(resParsers []ParseResponse) {
+	resChan := m.getResChan()
+	m.spawn(func() {
+		for res := range resChan {
+			res := res
+			analyzer, err := m.analyzerPool.TakeContext(m.ctx)
+			if err != nil {
+				if httpRes := res.Get(); httpRes != nil && httpRes.Body != nil {
+					httpRes.Body.Close()
+				}
+				if m.ctx.Err() != nil {
+					m.stopSign.Deal(ANALYZER_CODE)
+					continue
+				}
+				m.sendError(err, SCHEDULER_CODE)
+				continue
+			}
+			m.spawn(func() {
+				m.analyze(analyzer, resParsers, res)
+			})
+		}
+	})
+}
+
+func (m *myScheduler) analyze(analyzer Analyzer, resParsers []ParseResponse, res base.Response) {
+	defer func() {
+		if p := recover(); p != nil {
+			errMsg := fmt.Sprintf("Fatal analysis error: %s", p)
+			m.sendError(errors.New(errMsg), SCHEDULER_CODE)
+		}
+	}()
+	defer func() {
+		if err := m.analyzerPool.Return(analyzer); err != nil {
+			m.sendError(err, SCHEDULER_CODE)
+		}
+	}()
+	code := generateCode(ANALYZER_CODE, analyzer.Id())
+	if m.stopSign.Signed() {
+		if httpRes := res.Get(); httpRes != nil && httpRes.Body != nil {
+			httpRes.Body.Close()
+		}
+		m.stopSign.Deal(code)
+		return
+	}
+	dataList, errs := analyzer.AnalyzeContext(m.ctx, resParsers, res)
+	if httpRes := res.Get(); httpRes != nil && httpRes.Body != nil {
+		httpRes.Body.Close()
+	}
+	if m.ctx.Err() == nil {
+		atomic.AddUint64(&m.analyzed, 1)
+	}
+	var req *base.Request
+	if httpRes := res.Get(); httpRes != nil && httpRes.Request != nil {
+		req = base.NewRequest(httpRes.Request, res.Depth())
+		req.SetAttempt(res.Attempt())
+	}
+	for _, data := range dataList {
+		switch d := data.(type) {
+		case *base.Request:
+			m.saveReqToCache(*d, &res)
+		case *base.Item:
+			m.sendItem(*d)
+		default:
+			errMsg := fmt.Sprintf("Unsupported data type '%T'! (value=%v)", d, d)
+			m.sendRequestError(errors.New(errMsg), code, req)
+		}
+	}
+	for _, err := range errs {
+		m.sendRequestError(err, code, req)
+	}
+	m.finish(originalRequestKey(res))
+}
+
+// finish marks the request done unless the crawl is stopped, the requests interrupted
+// by stopping are kept for the checkpoint
+func (m *myScheduler) finish(key string) {
+	if m.outstanding == nil || m.ctx.Err() != nil {
+		return
+	}
+	m.outstanding.remove(key)
+}
+
+// resume restores the counters of the checkpoint and puts the saved requests into request
+// cache, the saved segments are adopted by the on-disk cache when it's created
+func (m *myScheduler) resume(state *checkpointState) {
+	counters := state.counters
+	if restorer, ok := m.itemPipeline.(interface {
+		restoreCount(sent uint64, accepted uint64, processed uint64)
+	}); ok {
+		restorer.restoreCount(counters.Sent, counters.Accepted, counters.Processed)
+	}
+	atomic.StoreUint64(&m.duplicates, counters.Duplicates)
+	atomic.StoreUint64(&m.retries, counters.Retries)
+	atomic.StoreUint64(&m.downloaded, counters.Downloaded)
+	atomic.StoreUint64(&m.analyzed, counters.Analyzed)
+	atomic.StoreUint64(&m.itemsProcessed, counters.ItemsProcessed)
+	if restorer, ok := m.scope.(interface {
+		restoreRejected(rejected map[string]uint64)
+	}); ok && counters.ScopeRejected != nil {
+		restorer.restoreRejected(counters.ScopeRejected)
+	}
+	for _, req := range state.reqs {
+		m.seenSet.Add(base.RequestKey(req))
+		m.reqCache.put(req, m.priorityOf(req, nil))
+	}
+}
+
+func (m *myScheduler) checkpoint() error {
+	m.checkpointLock.Lock()
+	defer m.checkpointLock.Unlock()
+	sent, accepted, processed := m.itemPipeline.Count()
+	counters := checkpointCounters{
+		Time:           time.Now(),
+		Sent:           sent,
+		Accepted:       accepted,
+		Processed:      processed,
+		Duplicates:     atomic.LoadUint64(&m.duplicates),
+		Retries:        atomic.LoadUint64(&m.retries),
+		Downloaded:     atomic.LoadUint64(&m.downloaded),
+		Analyzed:       atomic.LoadUint64(&m.analyzed),
+		ItemsProcessed: atomic.LoadUint64(&m.itemsProcessed),
+	}
+	if m.scope != nil {
+		counters.ScopeRejected = m.scope.Rejected()
+	}
+	m.frontierLock.Lock()
+	waiting, segments := m.reqCache.snapshot()
+	reqs := append(m.outstanding.list(), waiting...)
+	seen, err := snapshotSeenSet(m.seenSet)
+	m.frontierLock.Unlock()
+	if err != nil {
+		return err
+	}
+	state := checkpointState{reqs: reqs, segments: segments, counters: counters, seen: seen}
+	if err := saveCheckpoint(m.checkpointDir, state); err != nil {
+		return err
+	}
+	if releaser, ok := m.reqCache.(interface {
+		release()
+	}); ok {
+		releaser.release()
+	}
+	return m.saveCookies()
+}
+
+func (m *myScheduler) saveCookies() error {
+	if m.sessions == nil || m.sessionArgs.CookieFile == "" {
+		return nil
+	}
+	return saveCookieFile(m.sessions, m.sessionArgs.CookieFile)
+}
+
+// a seed keeps the session it's given, otherwise it gets its own one if the sessions are isolated
+func (m *myScheduler) sessionOfSeed(seed *base.Request) string {
+	if seed.Session() != "" || m.sessionArgs == nil || !m.sessionArgs.Isolated {
+		return seed.Session()
+	}
+	return seedSession(seed)
+}
+
+func (m *myScheduler) startCheckpointing(interval time.Duration) {
+	ctx := m.ctx
+	m.spawn(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.checkpoint(); err != nil {
+					m.sendError(err, SCHEDULER_CODE)
+				}
+			}
+		}
+	})
+}
+
+func (m *myScheduler) openItemPipeline() {
+	itemChan := m.getItemChan()
+	m.itemPipeline.SetFailFast(true)
+	m.spawn(func() {
+		for item := range itemChan {
+			item := item
+			m.spawn(func() {
+				defer func() {
+					if p := recover(); p != nil {
+						errMsg := fmt.Sprintf("Fatal item processing error: %s", p)
+						m.sendError(errors.New(errMsg), SCHEDULER_CODE)
+					}
+				}()
+				if m.stopSign.Signed() {
+					m.stopSign.Deal(ITEMPIPELINE_CODE)
+					return
+				}
+				errs := m.itemPipeline.SendContext(m.ctx, item)
+				if m.ctx.Err() == nil {
+					atomic.AddUint64(&m.itemsProcessed, 1)
+				}
+				for _, err := range errs {
+					m.sendError(err, ITEMPIPELINE_CODE)
+				}
+			})
+		}
+	})
+}
+
+// move the cached requests to request channel as long as there is room, with politeness
+// the requests queued by host take the room too
+func (m *myScheduler) schedule(interval time.Duration) {
+	reqChan := m.getReqChan()
+	m.spawn(func() {
+		for {
+			if m.stopSign.Signed() {
+				m.stopSign.Deal(SCHEDULER_CODE)
+				return
+			}
+			//the requests are kept in the cache while paused or draining
+			if m.Paused() || atomic.LoadUint32(&m.draining) == 1 {
+				time.Sleep(interval)
+				continue
+			}
+			remainder := cap(reqChan) - len(reqChan)
+			if m.politeness != nil {
+				//the requests queued by host or being downloaded are still counted, so the
+				//frontier stays in the cache and is got in the order of the strategy
+				queued := int(m.politeness.Pending()) + len(reqChan)
+				if room := int(m.channelLen) - queued; room < remainder {
+					remainder = room
+				}
+			}
+			for remainder > 0 {
+				req := m.takeRequest()
+				if req == nil {
+					break
+				}
+				if !m.sendReq(reqChan, *req) {
+					m.stopSign.Deal(SCHEDULER_CODE)
+					return
+				}
+				remainder--
+			}
+			time.Sleep(interval)
+		}
+	})
+}
+
+// takeRequest gets a request from the cache, it's outstanding until it's finished
+func (m *myScheduler) takeRequest() *base.Request {
+	if m.outstanding == nil {
+		return m.reqCache.get()
+	}
+	m.frontierLock.Lock()
+	defer m.frontierLock.Unlock()
+	req := m.reqCache.get()
+	if req != nil {
+		m.outstanding.add(req)
+	}
+	return req
+}
+
+// sendReq returns false if the scheduler is stopped before the request is sent
+func (m *myScheduler) sendReq(reqChan chan base.Request, req base.Request) bool {
+	m.sendLock.RLock()
+	defer m.sendLock.RUnlock()
+	if m.stopSign.Signed() {
+		return false
+	}
+	select {
+	case reqChan <- req:
+		return true
+	case <-m.ctx.Done():
+		return false
+	}
+}
+
+// parent is the response the request is found in
+func (m *myScheduler) saveReqToCache(req base.Request, parent *base.Response) bool {
+	return m.acceptRequest(req, parent) == nil
+}
+
+// acceptRequest puts the request into the cache, the error tells why it's not accepted
+func (m *myScheduler) acceptRequest(req base.Request, parent *base.Response) error {
+	if !req.Valid() {
+		return errors.New("The request is invalid!")
+	}
+	reqUrl := req.Get().URL
+	scheme := strings.ToLower(reqUrl.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return rejectedError(base.SCHEDULER_ERROR, "The scheme of the request is not supported!", &req)
+	}
+	if parent != nil && req.Session() == "" {
+		req.SetSession(parent.Session())
+	}
+	if req.Depth() > m.crawlDepth {
+		errMsg := fmt.Sprintf("The request is too deep! (crawlDepth=%d)", m.crawlDepth)
+		return rejectedError(base.SCHEDULER_ERROR, errMsg, &req)
+	}
+	if m.scope != nil {
+		check := m.scope.Check
+		if parent == nil {
+			//the request added by AddRequest is a seed
+			check = m.scope.CheckSeed
+		}
+		if reason := check(reqUrl); reason != "" {
+			errMsg := fmt.Sprintf("The request is out of scope! (reason=%s)", reason)
+			return rejectedError(base.SCOPE_ERROR, errMsg, &req)
+		}
+	}
+	if m.stopSign.Signed() {
+		return rejectedError(base.SCHEDULER_ERROR, "The scheduler is stopped!", &req)
+	}
+	if m.outstanding != nil {
+		m.frontierLock.Lock()
+		defer m.frontierLock.Unlock()
+	}
+	if !m.seenSet.Add(base.RequestKey(&req)) {
+		atomic.AddUint64(&m.duplicates, 1)
+		return rejectedError(base.SCHEDULER_ERROR, "The request is a duplicate!", &req)
+	}
+	if !m.reqCache.put(&req, m.priorityOf(&req, parent)) {
+		return rejectedError(base.SCHEDULER_ERROR, "The request cache is closed!", &req)
+	}
+	return nil
+}
+
+// the requests rejected by the scheduler never succeed if they are added again
+func rejectedError(errType base.ErrorType, errMsg string, req *base.Request) error {
+	return base.NewCrawlerErrorWithDetail(errType, errMsg, base.ErrorDetail{
+		Url:         req.Get().URL.String(),
+		Depth:       req.Depth(),
+		ComponentId: SCHEDULER_CODE,
+	})
+}
+
+func (m *myScheduler) priorityOf(req *base.Request, parent *base.Response) float64 {
+	if m.strategy == nil {
+		return 0
+	}
+	return m.strategy(req, parent)
+}
+
+func (m *myScheduler) sendRes(res base.Response, code string) bool {
+	m.sendLock.RLock()
+	defer m.sendLock.RUnlock()
+	if m.stopSign.Signed() {
+		m.stopSign.Deal(code)
+		return false
+	}
+	select {
+	case m.getResChan() <- res:
+		return true
+	case <-m.ctx.Done():
+		m.stopSign.Deal(code)
+		return false
+	}
+}
+
+// sendItem counts the item as dropped by the item pipeline if the scheduler is stopped
+func (m *myScheduler) sendItem(item base.Item) bool {
+	m.sendLock.RLock()
+	defer m.sendLock.RUnlock()
+	if m.stopSign.Signed() {
+		m.stopSign.Deal(ITEMPIPELINE_CODE)
+		return false
+	}
+	select {
+	case m.getItemChan() <- item:
+		return true
+	case <-m.ctx.Done():
+		m.stopSign.Deal(ITEMPIPELINE_CODE)
+		return false
+	}
+}
+
+// errors are sent asynchronously, so a full error channel never blocks the crawl.
+// A crawler error keeps its own type, other errors are typed by the component code
+func (m *myScheduler) sendError(err error, code string) bool {
+	return m.sendRequestError(err, code, nil)
+}
+
+// the url, depth and attempt of the request are added to the error, req may be nil
+func (m *myScheduler) sendRequestError(err error, code string, req *base.Request) bool {
+	if err == nil {
+		return false
+	}
+	//the errors are not work of the stages, they are dropped without dealing
+	if m.stopSign.Signed() {
+		return false
+	}
+	detail := base.ErrorDetail{ComponentId: code}
+	if req != nil && req.Valid() {
+		detail.Url = req.Get().URL.String()
+		detail.Depth = req.Depth()
+		detail.Attempt = req.Attempt()
+	}
+	var cError base.CrawlerError
+	if e, ok := err.(base.CrawlerError); ok {
+		cError = base.CompleteCrawlerError(e, detail)
+	} else {
+		detail.Cause = err
+		detail.Retryable = base.IsRetryable(err)
+		cError = base.NewCrawlerErrorWithDetail(errorTypeOfCode(code), "", detail)
+	}
+	if m.metrics != nil {
+		m.metrics.countError(cError.Type())
+	}
+	errorChan, chanErr := m.chanman.ErrorChan()
+	if chanErr != nil {
+		return false
+	}
+	ctx := m.ctx
+	go func() {
+		m.sendLock.RLock()
+		defer m.sendLock.RUnlock()
+		if ctx.Err() != nil {
+			return
+		}
+		select {
+		case errorChan <- cError:
+		case <-ctx.Done():
+		}
+	}()
+	return true
+}
+
+func (m *myScheduler) getReqChan() chan base.Request {
+	reqChan, err := m.chanman.ReqChan()
+	if err != nil {
+		panic(err)
+	}
+	return reqChan
+}
+
+func (m *myScheduler) getResChan() chan base.Response {
+	resChan, err := m.chanman.ResChan()
+	if err != nil {
+		panic(err)
+	}
+	return resChan
+}
+
+func (m *myScheduler) getItemChan() chan base.Item {
+	itemChan, err := m.chanman.ItemChan()
+	if err != nil {
+		panic(err)
+	}
+	return itemChan
+}
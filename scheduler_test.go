@@ -0,0 +1,196 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"gocrawler/base"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestSiteServer serves the pages /p<n>, each links to the next two pages
+func newTestSiteServer(hits *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits != nil {
+			atomic.AddInt64(hits, 1)
+		}
+		var n int
+		fmt.Sscanf(r.URL.Path, "/p%d", &n)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><a href="/p%d">a</a><a href="/p%d">b</a></html>`, n+1, n+2)
+	}))
+}
+
+func genTestClient() *http.Client {
+	return &http.Client{Timeout: 5 * time.Second}
+}
+
+// startTestScheduler starts the crawl of the site from /p0 with the link parser
+func startTestScheduler(t *testing.T, sched Scheduler, ctx context.Context, server *httptest.Server, crawlDepth uint32) {
+	err := sched.StartSeeds(ctx, 10, 3, crawlDepth, genTestClient, []ParseResponse{NewLinkParser()},
+		[]ProcessItem{}, []base.Request{*newTestRequest(t, server.URL+"/p0", 0)})
+	if err != nil {
+		t.Fatalf("StartSeeds: %s", err)
+	}
+}
+
+// waitIdle fails the test if the scheduler isn't idle in time
+func waitIdle(t *testing.T, sched Scheduler, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for !sched.Idle() {
+		if time.Now().After(deadline) {
+			t.Fatalf("the scheduler is not idle after %s", timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSchedulerCrawl(t *testing.T) {
+	var hits int64
+	server := newTestSiteServer(&hits)
+	defer server.Close()
+	sched := NewScheduler()
+	startTestScheduler(t, sched, context.Background(), server, 3)
+	waitIdle(t, sched, 5*time.Second)
+	if !sched.Stop() {
+		t.Fatal("Stop = false, want true")
+	}
+	//p0 at depth 0, p1-p2 at depth 1, p3-p4 at depth 2 and p5-p6 at depth 3
+	if got := atomic.LoadInt64(&hits); got != 7 {
+		t.Errorf("%d pages downloaded, want 7", got)
+	}
+	if sched.Stop() {
+		t.Error("Stop of a stopped scheduler = true, want false")
+	}
+}
+
+func TestSchedulerStopStart(t *testing.T) {
+	server := newTestSiteServer(nil)
+	defer server.Close()
+	sched := NewScheduler()
+	for i := 0; i < 30; i++ {
+		startTestScheduler(t, sched, context.Background(), server, 10)
+		time.Sleep(time.Duration(i%3) * time.Millisecond)
+		if !sched.Stop() {
+			t.Fatalf("run %d: Stop = false, want true", i)
+		}
+	}
+	var hits int64
+	finalServer := newTestSiteServer(&hits)
+	defer finalServer.Close()
+	startTestScheduler(t, sched, context.Background(), finalServer, 3)
+	waitIdle(t, sched, 5*time.Second)
+	sched.Stop()
+	if got := atomic.LoadInt64(&hits); got != 7 {
+		t.Errorf("%d pages downloaded after restarts, want 7", got)
+	}
+}
+
+func TestSchedulerStopByContext(t *testing.T) {
+	server := newTestSiteServer(nil)
+	defer server.Close()
+	sched := NewScheduler()
+	ctx, cancel := context.WithCancel(context.Background())
+	startTestScheduler(t, sched, ctx, server, 10)
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for sched.Summary("").Status != "stopped" {
+		if time.Now().After(deadline) {
+			t.Fatal("the scheduler is not stopped after the context is done")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	//the watcher of the first run must not stop the second one
+	startTestScheduler(t, sched, context.Background(), server, 10)
+	time.Sleep(50 * time.Millisecond)
+	if !sched.Running() {
+		t.Error("the restarted scheduler is stopped by the context of the last run")
+	}
+	sched.Stop()
+}
+
+func TestSchedulerPauseResume(t *testing.T) {
+	var hits int64
+	server := newTestSiteServer(&hits)
+	defer server.Close()
+	sched := NewScheduler()
+	startTestScheduler(t, sched, context.Background(), server, 0)
+	waitIdle(t, sched, 5*time.Second)
+	if !sched.Pause() || sched.Running() || !sched.Paused() {
+		t.Fatal("the scheduler is not paused")
+	}
+	if sched.Pause() {
+		t.Error("Pause of a paused scheduler = true, want false")
+	}
+	for i := 1; i <= 3; i++ {
+		if err := sched.AddRequest(*newTestRequest(t, fmt.Sprintf("%s/q%d", server.URL, i), 0)); err != nil {
+			t.Fatalf("AddRequest while paused: %s", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := atomic.LoadInt64(&hits); got != 1 {
+		t.Errorf("%d pages downloaded while paused, want 1", got)
+	}
+	if sched.Idle() {
+		t.Error("Idle of a paused scheduler with queued requests = true, want false")
+	}
+	if !sched.Resume() || !sched.Running() {
+		t.Fatal("the scheduler is not resumed")
+	}
+	if sched.Resume() {
+		t.Error("Resume of a running scheduler = true, want false")
+	}
+	waitIdle(t, sched, 5*time.Second)
+	if got := atomic.LoadInt64(&hits); got != 4 {
+		t.Errorf("%d pages downloaded after resume, want 4", got)
+	}
+	sched.Stop()
+}
+
+func TestSchedulerPausedDispatchNotIdle(t *testing.T) {
+	var hits int64
+	server := newTestSiteServer(&hits)
+	defer server.Close()
+	sched := NewScheduler().(*myScheduler)
+	startTestScheduler(t, sched, context.Background(), server, 0)
+	waitIdle(t, sched, 5*time.Second)
+	sched.Pause()
+	//the request taken by the downloader stage before pausing waits in dispatch
+	sched.getReqChan() <- *newTestRequest(t, server.URL+"/q", 0)
+	time.Sleep(50 * time.Millisecond)
+	if sched.Idle() {
+		t.Error("Idle with a request waiting for Resume = true, want false")
+	}
+	sched.Resume()
+	waitIdle(t, sched, 5*time.Second)
+	if got := atomic.LoadInt64(&hits); got != 2 {
+		t.Errorf("%d pages downloaded, want 2", got)
+	}
+	sched.Stop()
+}
+
+func TestSchedulerPauseStopStart(t *testing.T) {
+	server := newTestSiteServer(nil)
+	defer server.Close()
+	sched := NewScheduler()
+	startTestScheduler(t, sched, context.Background(), server, 3)
+	sched.Pause()
+	if !sched.Stop() {
+		t.Fatal("Stop of a paused scheduler = false, want true")
+	}
+	var hits int64
+	finalServer := newTestSiteServer(&hits)
+	defer finalServer.Close()
+	startTestScheduler(t, sched, context.Background(), finalServer, 3)
+	if sched.Paused() || !sched.Running() {
+		t.Fatal("the restarted scheduler is not running")
+	}
+	waitIdle(t, sched, 5*time.Second)
+	sched.Stop()
+	if got := atomic.LoadInt64(&hits); got != 7 {
+		t.Errorf("%d pages downloaded after restarting a paused scheduler, want 7", got)
+	}
+}
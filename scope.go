@@ -0,0 +1,246 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// the reasons why a url is out of scope
+const (
+	SCOPE_REJECT_DOMAIN    = "domain"
+	SCOPE_REJECT_ORIGIN    = "origin"
+	SCOPE_REJECT_INCLUDE   = "include"
+	SCOPE_REJECT_EXCLUDE   = "exclude"
+	SCOPE_REJECT_PATH      = "path"
+	SCOPE_REJECT_QUERY     = "query"
+	SCOPE_REJECT_EXTENSION = "extension"
+)
+
+type ScopeArgs struct {
+	//the domains allowed to crawl, their subdomains are allowed as well. Empty means any domain
+	AllowedDomains []string
+	//only crawl the urls with the same scheme, host and port as one of the seeds
+	SameOriginAsSeed bool
+	//the url must match one of the regexps if there is any
+	IncludePatterns []string
+	//the url must not match any of the regexps
+	ExcludePatterns []string
+	//the path must start with one of the prefixes if there is any
+	PathPrefixes []string
+	//the max number of query parameters, 0 means unlimited
+	MaxQueryParams int
+	//the file extensions never crawled, e.g. ".pdf" or "jpg"
+	ExcludeExtensions []string
+}
+
+// Scope decides whether a url found in pages should be crawled
+type Scope interface {
+	//register the origin of a seed for SameOriginAsSeed
+	AddSeed(u *url.URL)
+	//get the reason why the url is out of scope, empty if it's in scope
+	Check(u *url.URL) string
+	//like Check but for a seed, whose origin is allowed even if it's not registered yet
+	CheckSeed(u *url.URL) string
+	//get the number of rejected urls by reason
+	Rejected() map[string]uint64
+	//get the summary info
+	Summary() string
+}
+
+type myScope struct {
+	args       ScopeArgs
+	domains    []string
+	includes   []*regexp.Regexp
+	excludes   []*regexp.Regexp
+	extensions map[string]bool
+	origins    map[string]bool
+	rejected   map[string]uint64
+	mutex      sync.Mutex
+}
+
+func NewScope(args ScopeArgs) (Scope, error) {
+	scope := &myScope{
+		args:       args,
+		extensions: make(map[string]bool),
+		origins:    make(map[string]bool),
+		rejected:   make(map[string]uint64),
+	}
+	for _, domain := range args.AllowedDomains {
+		domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain == "" {
+			return nil, errors.New("The allowed domain is empty!")
+		}
+		scope.domains = append(scope.domains, domain)
+	}
+	for _, pattern := range args.IncludePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		scope.includes = append(scope.includes, re)
+	}
+	for _, pattern := range args.ExcludePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		scope.excludes = append(scope.excludes, re)
+	}
+	for _, ext := range args.ExcludeExtensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		scope.extensions[ext] = true
+	}
+	return scope, nil
+}
+
+func originOf(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = defaultPortOf(scheme)
+	}
+	return scheme + "://" + host + ":" + port
+}
+
+func defaultPortOf(scheme string) string {
+	switch scheme {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+	return ""
+}
+
+func (m *myScope) AddSeed(u *url.URL) {
+	if u == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.origins[originOf(u)] = true
+}
+
+func (m *myScope) Check(u *url.URL) string {
+	return m.count(m.check(u, false))
+}
+
+func (m *myScope) CheckSeed(u *url.URL) string {
+	return m.count(m.check(u, true))
+}
+
+// count the rejection of the reason if it's not empty
+func (m *myScope) count(reason string) string {
+	if reason != "" {
+		m.mutex.Lock()
+		m.rejected[reason]++
+		m.mutex.Unlock()
+	}
+	return reason
+}
+
+func (m *myScope) check(u *url.URL, seed bool) string {
+	if len(m.domains) > 0 && !m.allowedDomain(u.Hostname()) {
+		return SCOPE_REJECT_DOMAIN
+	}
+	if m.args.SameOriginAsSeed && !seed {
+		m.mutex.Lock()
+		sameOrigin := m.origins[originOf(u)]
+		m.mutex.Unlock()
+		if !sameOrigin {
+			return SCOPE_REJECT_ORIGIN
+		}
+	}
+	rawUrl := u.String()
+	if len(m.includes) > 0 && !matchAny(m.includes, rawUrl) {
+		return SCOPE_REJECT_INCLUDE
+	}
+	if matchAny(m.excludes, rawUrl) {
+		return SCOPE_REJECT_EXCLUDE
+	}
+	if len(m.args.PathPrefixes) > 0 && !hasAnyPrefix(u.Path, m.args.PathPrefixes) {
+		return SCOPE_REJECT_PATH
+	}
+	if m.args.MaxQueryParams > 0 && len(u.Query()) > m.args.MaxQueryParams {
+		return SCOPE_REJECT_QUERY
+	}
+	if m.extensions[strings.ToLower(path.Ext(u.Path))] {
+		return SCOPE_REJECT_EXTENSION
+	}
+	return ""
+}
+
+func (m *myScope) allowedDomain(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, domain := range m.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *myScope) Rejected() map[string]uint64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := make(map[string]uint64, len(m.rejected))
+	for reason, count := range m.rejected {
+		result[reason] = count
+	}
+	return result
+}
+
+// restoreRejected replaces the rejection counts by the ones saved in a checkpoint
+func (m *myScope) restoreRejected(rejected map[string]uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rejected = make(map[string]uint64, len(rejected))
+	for reason, count := range rejected {
+		m.rejected[reason] = count
+	}
+}
+
+func (m *myScope) Summary() string {
+	rejected := m.Rejected()
+	reasons := make([]string, 0, len(rejected))
+	for reason := range rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	parts := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		parts = append(parts, fmt.Sprintf("%s: %d", reason, rejected[reason]))
+	}
+	return "rejected: {" + strings.Join(parts, ", ") + "}"
+}
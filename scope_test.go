@@ -0,0 +1,95 @@
+package crawler
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestScopeCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   ScopeArgs
+		rawUrl string
+		want   string
+	}{
+		{"no limit", ScopeArgs{}, "http://any.com/a.pdf?a=1&b=2", ""},
+		{"allowed domain", ScopeArgs{AllowedDomains: []string{"Example.com."}}, "http://example.com/", ""},
+		{"allowed subdomain", ScopeArgs{AllowedDomains: []string{"example.com"}}, "http://www.EXAMPLE.com/", ""},
+		{"other domain", ScopeArgs{AllowedDomains: []string{"example.com"}}, "http://badexample.com/", SCOPE_REJECT_DOMAIN},
+		{"parent domain", ScopeArgs{AllowedDomains: []string{"www.example.com"}}, "http://example.com/", SCOPE_REJECT_DOMAIN},
+		{"same origin", ScopeArgs{SameOriginAsSeed: true}, "http://example.com:80/a", ""},
+		{"other scheme", ScopeArgs{SameOriginAsSeed: true}, "https://example.com/a", SCOPE_REJECT_ORIGIN},
+		{"other port", ScopeArgs{SameOriginAsSeed: true}, "http://example.com:8080/a", SCOPE_REJECT_ORIGIN},
+		{"included", ScopeArgs{IncludePatterns: []string{`/news/`, `/blog/`}}, "http://example.com/blog/1", ""},
+		{"not included", ScopeArgs{IncludePatterns: []string{`/news/`}}, "http://example.com/blog/1", SCOPE_REJECT_INCLUDE},
+		{"excluded", ScopeArgs{ExcludePatterns: []string{`\?sort=`}}, "http://example.com/a?sort=asc", SCOPE_REJECT_EXCLUDE},
+		{"path prefix", ScopeArgs{PathPrefixes: []string{"/docs"}}, "http://example.com/docs/a", ""},
+		{"other path", ScopeArgs{PathPrefixes: []string{"/docs"}}, "http://example.com/blog/a", SCOPE_REJECT_PATH},
+		{"query params", ScopeArgs{MaxQueryParams: 2}, "http://example.com/?a=1&b=2", ""},
+		{"too many query params", ScopeArgs{MaxQueryParams: 2}, "http://example.com/?a=1&b=2&c=3", SCOPE_REJECT_QUERY},
+		{"extension", ScopeArgs{ExcludeExtensions: []string{"pdf", ".JPG"}}, "http://example.com/a.PDF", SCOPE_REJECT_EXTENSION},
+		{"extension with dot", ScopeArgs{ExcludeExtensions: []string{"pdf", ".JPG"}}, "http://example.com/a.jpg", SCOPE_REJECT_EXTENSION},
+		{"other extension", ScopeArgs{ExcludeExtensions: []string{"pdf"}}, "http://example.com/a.html", ""},
+		{"domain checked first", ScopeArgs{AllowedDomains: []string{"example.com"}, PathPrefixes: []string{"/docs"}}, "http://other.com/a", SCOPE_REJECT_DOMAIN},
+	}
+	for _, test := range tests {
+		scope, err := NewScope(test.args)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		seed, _ := url.Parse("http://Example.com/")
+		scope.AddSeed(seed)
+		u, err := url.Parse(test.rawUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := scope.Check(u); got != test.want {
+			t.Errorf("%s: Check(%q) = %q, want %q", test.name, test.rawUrl, got, test.want)
+		}
+		if got := scope.Rejected()[test.want]; test.want != "" && got != 1 {
+			t.Errorf("%s: Rejected()[%q] = %d, want 1", test.name, test.want, got)
+		}
+	}
+}
+
+func TestScopeCheckSeed(t *testing.T) {
+	scope, err := NewScope(ScopeArgs{AllowedDomains: []string{"example.com"}, SameOriginAsSeed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rawUrl string
+		want   string
+	}{
+		{"https://example.com/", ""},
+		{"http://www.example.com:8080/", ""},
+		{"http://other.com/", SCOPE_REJECT_DOMAIN},
+	}
+	for _, test := range tests {
+		u, _ := url.Parse(test.rawUrl)
+		if got := scope.CheckSeed(u); got != test.want {
+			t.Errorf("CheckSeed(%q) = %q, want %q", test.rawUrl, got, test.want)
+		}
+		//the origin of a seed is not registered by CheckSeed
+		if got := scope.Check(u); test.want == "" && got != SCOPE_REJECT_ORIGIN {
+			t.Errorf("Check(%q) before AddSeed = %q, want %q", test.rawUrl, got, SCOPE_REJECT_ORIGIN)
+		}
+		scope.AddSeed(u)
+		if got := scope.Check(u); got != test.want {
+			t.Errorf("Check(%q) after AddSeed = %q, want %q", test.rawUrl, got, test.want)
+		}
+	}
+}
+
+func TestNewScopeInvalid(t *testing.T) {
+	tests := []ScopeArgs{
+		{AllowedDomains: []string{" . "}},
+		{IncludePatterns: []string{"("}},
+		{ExcludePatterns: []string{"["}},
+	}
+	for _, args := range tests {
+		if _, err := NewScope(args); err == nil {
+			t.Errorf("NewScope(%+v) error = nil, want error", args)
+		}
+	}
+}
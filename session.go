@@ -0,0 +1,249 @@
+package crawler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gocrawler/base"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+type SessionArgs struct {
+	//whether each seed gets an isolated session, the requests found in a page belong to the
+	//session of the page. All the requests share one session if false
+	Isolated bool
+	//the cookies are loaded from the file at Start and saved to it at checkpoints and Stop,
+	//empty means the cookies are not persisted
+	CookieFile string
+}
+
+// CookieSessions keeps a cookie jar for each session, the jars are scoped by the public
+// suffix list, so a site can't set cookies for a whole public suffix like "co.uk"
+type CookieSessions interface {
+	//get the jar of the session, it's created on first use. The empty session is the shared one
+	Jar(session string) http.CookieJar
+	//get the sessions in use
+	Sessions() []string
+	//save the unexpired cookies of all the sessions as json
+	Save(w io.Writer) error
+	//load the cookies saved by Save, the expired ones are skipped
+	Load(r io.Reader) error
+}
+
+// savedCookie is the serialized form of a cookie, together with the url it was set by
+type savedCookie struct {
+	Url      string        `json:"url"`
+	Name     string        `json:"name"`
+	Value    string        `json:"value"`
+	Path     string        `json:"path,omitempty"`
+	Domain   string        `json:"domain,omitempty"`
+	Expires  time.Time     `json:"expires"`
+	Secure   bool          `json:"secure,omitempty"`
+	HttpOnly bool          `json:"http_only,omitempty"`
+	SameSite http.SameSite `json:"same_site,omitempty"`
+}
+
+func (s savedCookie) cookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     s.Name,
+		Value:    s.Value,
+		Path:     s.Path,
+		Domain:   s.Domain,
+		Expires:  s.Expires,
+		Secure:   s.Secure,
+		HttpOnly: s.HttpOnly,
+		SameSite: s.SameSite,
+	}
+}
+
+// recordingJar is a cookie jar which remembers the cookies set to it, because the standard
+// jar can't list its cookies for saving
+type recordingJar struct {
+	jar *cookiejar.Jar
+	//keyed by domain, path and name like the cookies in the jar
+	cookies map[string]savedCookie
+	mutex   sync.Mutex
+}
+
+func newRecordingJar() *recordingJar {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		errMsg := fmt.Sprintf("Failed to create cookie jar: %s", err)
+		panic(errors.New(errMsg))
+	}
+	return &recordingJar{
+		jar:     jar,
+		cookies: make(map[string]savedCookie),
+	}
+}
+
+func (j *recordingJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+func (j *recordingJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+	now := time.Now()
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for _, c := range cookies {
+		saved := savedCookie{
+			Url:      u.String(),
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			Expires:  c.Expires,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+			SameSite: c.SameSite,
+		}
+		if c.MaxAge > 0 {
+			saved.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		key := cookieKey(u, c)
+		if c.MaxAge < 0 || (!saved.Expires.IsZero() && !saved.Expires.After(now)) {
+			delete(j.cookies, key)
+			continue
+		}
+		j.cookies[key] = saved
+	}
+}
+
+// cookieKey identifies a cookie the way the jar does, a host-only cookie is keyed by its host
+func cookieKey(u *url.URL, c *http.Cookie) string {
+	domain := strings.TrimPrefix(strings.ToLower(c.Domain), ".")
+	if domain == "" {
+		domain = "host:" + strings.ToLower(u.Hostname())
+	}
+	cookiePath := c.Path
+	if cookiePath == "" || cookiePath[0] != '/' {
+		cookiePath = path.Dir(u.Path)
+		if u.Path == "" || u.Path[0] != '/' || cookiePath == "." {
+			cookiePath = "/"
+		}
+	}
+	return domain + ";" + cookiePath + ";" + c.Name
+}
+
+func (j *recordingJar) list(now time.Time) []savedCookie {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	cookies := make([]savedCookie, 0, len(j.cookies))
+	for _, saved := range j.cookies {
+		if saved.Expires.IsZero() || saved.Expires.After(now) {
+			cookies = append(cookies, saved)
+		}
+	}
+	sort.Slice(cookies, func(i, k int) bool {
+		if cookies[i].Url != cookies[k].Url {
+			return cookies[i].Url < cookies[k].Url
+		}
+		return cookies[i].Name < cookies[k].Name
+	})
+	return cookies
+}
+
+type myCookieSessions struct {
+	jars  map[string]*recordingJar
+	mutex sync.Mutex
+}
+
+func NewCookieSessions() CookieSessions {
+	return &myCookieSessions{jars: make(map[string]*recordingJar)}
+}
+
+func (s *myCookieSessions) Jar(session string) http.CookieJar {
+	return s.jar(session)
+}
+
+func (s *myCookieSessions) jar(session string) *recordingJar {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	jar, ok := s.jars[session]
+	if !ok {
+		jar = newRecordingJar()
+		s.jars[session] = jar
+	}
+	return jar
+}
+
+func (s *myCookieSessions) Sessions() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sessions := make([]string, 0, len(s.jars))
+	for session := range s.jars {
+		sessions = append(sessions, session)
+	}
+	sort.Strings(sessions)
+	return sessions
+}
+
+func (s *myCookieSessions) Save(w io.Writer) error {
+	now := time.Now()
+	saved := make(map[string][]savedCookie)
+	for _, session := range s.Sessions() {
+		if cookies := s.jar(session).list(now); len(cookies) > 0 {
+			saved[session] = cookies
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(saved)
+}
+
+func (s *myCookieSessions) Load(r io.Reader) error {
+	var saved map[string][]savedCookie
+	if err := json.NewDecoder(r).Decode(&saved); err != nil {
+		return err
+	}
+	now := time.Now()
+	for session, cookies := range saved {
+		jar := s.jar(session)
+		for _, cookie := range cookies {
+			if !cookie.Expires.IsZero() && !cookie.Expires.After(now) {
+				continue
+			}
+			u, err := url.Parse(cookie.Url)
+			if err != nil {
+				errMsg := fmt.Sprintf("Invalid url of the saved cookie! (url=%s)", cookie.Url)
+				return errors.New(errMsg)
+			}
+			jar.SetCookies(u, []*http.Cookie{cookie.cookie()})
+		}
+	}
+	return nil
+}
+
+// loadCookieFile loads the cookies from the file, a missing file is not an error
+func loadCookieFile(sessions CookieSessions, filePath string) error {
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return sessions.Load(file)
+}
+
+func saveCookieFile(sessions CookieSessions, filePath string) error {
+	return writeFileAtomic(filePath, sessions.Save)
+}
+
+// seedSession names the isolated session of a seed after its url
+func seedSession(seed *base.Request) string {
+	return seed.Get().URL.String()
+}
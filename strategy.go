@@ -0,0 +1,142 @@
+package crawler
+
+import (
+	"container/heap"
+	"fmt"
+	"gocrawler/base"
+	"net/url"
+	"sync"
+)
+
+// CrawlStrategy returns the priority of a request, the waiting request with the highest
+// priority is downloaded first and requests with the same priority are downloaded in the
+// order they are found. parent is the response the request is found in, it's nil for seeds
+type CrawlStrategy func(req *base.Request, parent *base.Response) float64
+
+// BreadthFirst downloads the requests with smaller depth first
+func BreadthFirst() CrawlStrategy {
+	return func(req *base.Request, parent *base.Response) float64 {
+		return -float64(req.Depth())
+	}
+}
+
+// DepthFirst downloads the requests with larger depth first
+func DepthFirst() CrawlStrategy {
+	return func(req *base.Request, parent *base.Response) float64 {
+		return float64(req.Depth())
+	}
+}
+
+// BestFirst downloads the requests with higher score first
+func BestFirst(score func(u *url.URL, parent *base.Response) float64) CrawlStrategy {
+	return func(req *base.Request, parent *base.Response) float64 {
+		return score(req.Get().URL, parent)
+	}
+}
+
+type prioritizedRequest struct {
+	req      *base.Request
+	priority float64
+	seq      uint64
+}
+
+// reqHeap implements heap.Interface, the highest priority and then the smallest seq is on top
+type reqHeap []prioritizedRequest
+
+func (h reqHeap) Len() int {
+	return len(h)
+}
+
+func (h reqHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h reqHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *reqHeap) Push(x interface{}) {
+	*h = append(*h, x.(prioritizedRequest))
+}
+
+func (h *reqHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	old[len(old)-1] = prioritizedRequest{}
+	*h = old[:len(old)-1]
+	return last
+}
+
+type reqCacheByPriority struct {
+	cache  reqHeap
+	seq    uint64
+	mutex  sync.Mutex
+	status byte //0: running, 1: closed
+}
+
+func newPriorityRequestCache() requestCache {
+	return &reqCacheByPriority{
+		cache: make(reqHeap, 0),
+	}
+}
+
+func (r *reqCacheByPriority) put(req *base.Request, priority float64) bool {
+	if req == nil {
+		return false
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.status == 1 {
+		return false
+	}
+	heap.Push(&r.cache, prioritizedRequest{req: req, priority: priority, seq: r.seq})
+	r.seq++
+	return true
+}
+
+func (r *reqCacheByPriority) get() *base.Request {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.cache) == 0 || r.status == 1 {
+		return nil
+	}
+	return heap.Pop(&r.cache).(prioritizedRequest).req
+}
+
+func (r *reqCacheByPriority) capacity() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return cap(r.cache)
+}
+
+func (r *reqCacheByPriority) length() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.cache)
+}
+
+func (r *reqCacheByPriority) snapshot() ([]*base.Request, []savedSegment) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	reqs := make([]*base.Request, 0, len(r.cache))
+	for _, item := range r.cache {
+		reqs = append(reqs, item.req)
+	}
+	return reqs, nil
+}
+
+func (r *reqCacheByPriority) close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.status = 1
+}
+
+func (r *reqCacheByPriority) summary() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	summaryTemplate := "status: %s, length: %d, capacity: %d, ordered: true"
+	return fmt.Sprintf(summaryTemplate, reqCacheStatusMap[r.status], len(r.cache), cap(r.cache))
+}
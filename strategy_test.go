@@ -0,0 +1,101 @@
+package crawler
+
+import (
+	"gocrawler/base"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newTestRequest(t *testing.T, rawUrl string, depth uint32) *base.Request {
+	httpReq, err := http.NewRequest("GET", rawUrl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base.NewRequest(httpReq, depth)
+}
+
+func TestPriorityRequestCacheOrder(t *testing.T) {
+	type entry struct {
+		name     string
+		priority float64
+	}
+	tests := []struct {
+		name    string
+		entries []entry
+		want    string
+	}{
+		{"empty", nil, ""},
+		{"highest first", []entry{{"a", 1}, {"b", 3}, {"c", 2}}, "b c a"},
+		{"fifo on ties", []entry{{"a", 0}, {"b", 0}, {"c", 0}, {"d", 0}}, "a b c d"},
+		{"mixed", []entry{{"a", 1}, {"b", 2}, {"c", 1}, {"d", 2}, {"e", -1}}, "b d a c e"},
+	}
+	for _, test := range tests {
+		cache := newPriorityRequestCache()
+		for _, e := range test.entries {
+			if !cache.put(newTestRequest(t, "http://example.com/"+e.name, 0), e.priority) {
+				t.Fatalf("%s: put(%s) = false, want true", test.name, e.name)
+			}
+		}
+		if cache.length() != len(test.entries) {
+			t.Errorf("%s: length = %d, want %d", test.name, cache.length(), len(test.entries))
+		}
+		got := make([]string, 0, len(test.entries))
+		for req := cache.get(); req != nil; req = cache.get() {
+			got = append(got, strings.TrimPrefix(req.Get().URL.Path, "/"))
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("%s: order = %q, want %q", test.name, strings.Join(got, " "), test.want)
+		}
+	}
+}
+
+func TestPriorityRequestCacheClosed(t *testing.T) {
+	cache := newPriorityRequestCache()
+	cache.put(newTestRequest(t, "http://example.com/a", 0), 0)
+	if cache.put(nil, 0) {
+		t.Errorf("put(nil) = true, want false")
+	}
+	cache.close()
+	if cache.put(newTestRequest(t, "http://example.com/b", 0), 0) {
+		t.Errorf("put after close = true, want false")
+	}
+	if req := cache.get(); req != nil {
+		t.Errorf("get after close = %s, want nil", req.Get().URL)
+	}
+}
+
+func TestCrawlStrategies(t *testing.T) {
+	score := func(u *url.URL, parent *base.Response) float64 {
+		return float64(len(u.Path))
+	}
+	tests := []struct {
+		name     string
+		strategy CrawlStrategy
+		want     string
+	}{
+		{"breadth first", BreadthFirst(), "/ /aaa /b /cc"},
+		{"depth first", DepthFirst(), "/cc /aaa /b /"},
+		{"best first", BestFirst(score), "/aaa /cc /b /"},
+	}
+	reqs := []*base.Request{
+		newTestRequest(t, "http://example.com/", 0),
+		newTestRequest(t, "http://example.com/aaa", 1),
+		newTestRequest(t, "http://example.com/b", 1),
+		newTestRequest(t, "http://example.com/cc", 2),
+	}
+	for _, test := range tests {
+		cache := newPriorityRequestCache()
+		for _, req := range reqs {
+			cache.put(req, test.strategy(req, nil))
+		}
+		got := make([]string, 0, len(reqs))
+		for req := cache.get(); req != nil; req = cache.get() {
+			got = append(got, req.Get().URL.Path)
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("%s: order = %q, want %q", test.name, strings.Join(got, " "), test.want)
+		}
+	}
+}
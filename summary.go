@@ -0,0 +1,370 @@
+package crawler
+
+import (
+	"bytes"
+	"fmt"
+	"gocrawler/middleware"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// SchedSummary is a snapshot of the scheduler and its components. It can be marshalled
+// to json, compared with a previous snapshot by Diff, and rendered by String and Detail
+type SchedSummary struct {
+	//the prefix of each line in the text form
+	Prefix     string        `json:"-"`
+	Time       time.Time     `json:"time"`
+	Status     string        `json:"status"`
+	Uptime     time.Duration `json:"uptime"`
+	ChannelLen uint32        `json:"channel_len"`
+	PoolSize   uint32        `json:"pool_size"`
+	CrawlDepth uint32        `json:"crawl_depth"`
+
+	Downloader   PoolSummary            `json:"downloader"`
+	Analyzer     PoolSummary            `json:"analyzer"`
+	Channels     ChannelsSummary        `json:"channels"`
+	RequestCache RequestCacheSummary    `json:"request_cache"`
+	ItemPipeline PipelineSummary        `json:"item_pipeline"`
+	StopSign     StopSignSummary        `json:"stop_sign"`
+	Urls         UrlsSummary            `json:"urls"`
+	SeenSet      middleware.SeenSetInfo `json:"seen_set"`
+	//nil if politeness is not enabled
+	Politeness *PolitenessSummary `json:"politeness,omitempty"`
+	//nil if robots.txt is ignored
+	Robots *RobotsSummary `json:"robots,omitempty"`
+	//nil if the responses are not cached
+	HttpCache *HttpCacheSummary `json:"http_cache,omitempty"`
+	//nil if the crawl is neither recorded nor replayed
+	Archive *ArchiveSummary `json:"archive,omitempty"`
+}
+
+type PoolSummary struct {
+	Used  uint32 `json:"used"`
+	Total uint32 `json:"total"`
+	//the number of requests downloaded or responses analyzed successfully
+	Completed uint64 `json:"completed"`
+}
+
+type ChannelSummary struct {
+	Length   int `json:"length"`
+	Capacity int `json:"capacity"`
+}
+
+type ChannelsSummary struct {
+	Status   string         `json:"status"`
+	Request  ChannelSummary `json:"request"`
+	Response ChannelSummary `json:"response"`
+	Item     ChannelSummary `json:"item"`
+	Error    ChannelSummary `json:"error"`
+}
+
+type RequestCacheSummary struct {
+	Length   int `json:"length"`
+	Capacity int `json:"capacity"`
+}
+
+type PipelineSummary struct {
+	FailFast   bool   `json:"fail_fast"`
+	Sent       uint64 `json:"sent"`
+	Accepted   uint64 `json:"accepted"`
+	Processed  uint64 `json:"processed"`
+	Processing uint64 `json:"processing"`
+}
+
+type StopSignSummary struct {
+	Signed     bool              `json:"signed"`
+	DealCounts map[string]uint32 `json:"deal_counts"`
+	DealTotal  uint32            `json:"deal_total"`
+}
+
+type UrlsSummary struct {
+	Seen       uint64 `json:"seen"`
+	Duplicates uint64 `json:"duplicates"`
+	Retries    uint64 `json:"retries"`
+	//the urls out of scope by reason, nil if scope is not enabled
+	Rejected map[string]uint64 `json:"rejected,omitempty"`
+}
+
+type PolitenessSummary struct {
+	Pending uint64 `json:"pending"`
+	Dropped uint64 `json:"dropped"`
+}
+
+type RobotsSummary struct {
+	UserAgent string `json:"user_agent"`
+	//the number of hosts whose robots.txt is cached
+	Hosts int `json:"hosts"`
+}
+
+type HttpCacheSummary struct {
+	Hits        uint64 `json:"hits"`
+	Revalidated uint64 `json:"revalidated"`
+	Misses      uint64 `json:"misses"`
+	Stored      uint64 `json:"stored"`
+	//the number of responses which failed to be stored
+	Failed uint64 `json:"failed"`
+}
+
+type ArchiveSummary struct {
+	//ARCHIVE_MODE_RECORD or ARCHIVE_MODE_REPLAY
+	Mode string `json:"mode"`
+	//the number of records written, only set when recording
+	Recorded uint64 `json:"recorded,omitempty"`
+	//the number of requests in the archive and the requests served from it or not found
+	//in it, only set when replaying
+	Requests int    `json:"requests,omitempty"`
+	Served   uint64 `json:"served,omitempty"`
+	Missing  uint64 `json:"missing,omitempty"`
+}
+
+func NewSchedSummary(sched *myScheduler, prefix string) SchedSummary {
+	summary := SchedSummary{Prefix: prefix, Time: time.Now()}
+	if sched == nil {
+		return summary
+	}
+	status := atomic.LoadUint32(&sched.running)
+	summary.Status = schedStatusNameMap[status]
+	summary.Downloader.Completed = atomic.LoadUint64(&sched.downloaded)
+	summary.Analyzer.Completed = atomic.LoadUint64(&sched.analyzed)
+	summary.Urls.Duplicates = atomic.LoadUint64(&sched.duplicates)
+	summary.Urls.Retries = atomic.LoadUint64(&sched.retries)
+	//components are only available after the scheduler has been started, the ones of the
+	//last run are read while it's started again
+	components := sched.loadComponents()
+	if components == nil {
+		return summary
+	}
+	endTime := summary.Time
+	if stopTime, ok := sched.stopTime.Load().(time.Time); ok && status == SCHED_STATUS_STOPPED &&
+		stopTime.After(components.startTime) {
+		endTime = stopTime
+	}
+	summary.Uptime = endTime.Sub(components.startTime)
+	summary.ChannelLen = components.channelLen
+	summary.PoolSize = components.poolSize
+	summary.CrawlDepth = components.crawlDepth
+	summary.Downloader.Used = components.dlpool.Used()
+	summary.Downloader.Total = components.dlpool.Total()
+	summary.Analyzer.Used = components.analyzerPool.Used()
+	summary.Analyzer.Total = components.analyzerPool.Total()
+	summary.Channels = channelsSummaryOf(components.chanman)
+	summary.RequestCache.Length = components.reqCache.length()
+	summary.RequestCache.Capacity = components.reqCache.capacity()
+	pipeline := &summary.ItemPipeline
+	pipeline.FailFast = components.itemPipeline.FailFast()
+	pipeline.Sent, pipeline.Accepted, pipeline.Processed = components.itemPipeline.Count()
+	pipeline.Processing = components.itemPipeline.ProcessingNumber()
+	summary.StopSign.Signed = components.stopSign.Signed()
+	summary.StopSign.DealCounts = components.stopSign.DealCounts()
+	summary.StopSign.DealTotal = components.stopSign.DealTotal()
+	summary.Urls.Seen = components.seenSet.Count()
+	if described, ok := components.seenSet.(middleware.DescribedSeenSet); ok {
+		summary.SeenSet = described.Info()
+	} else {
+		summary.SeenSet = middleware.SeenSetInfo{Type: "custom", Count: summary.Urls.Seen}
+	}
+	if components.scope != nil {
+		summary.Urls.Rejected = components.scope.Rejected()
+	}
+	if components.politeness != nil {
+		summary.Politeness = &PolitenessSummary{
+			Pending: components.politeness.Pending(),
+			Dropped: components.politeness.Dropped(),
+		}
+	}
+	if components.robots != nil {
+		summary.Robots = &RobotsSummary{
+			UserAgent: components.robotsUserAgent,
+			Hosts:     components.robots.Hosts(),
+		}
+	}
+	if components.httpCache != nil {
+		httpCache := components.httpCache.summary()
+		summary.HttpCache = &httpCache
+	}
+	if components.recorder != nil {
+		archive := components.recorder.summary()
+		summary.Archive = &archive
+	} else if components.replay != nil {
+		archive := components.replay.summary()
+		summary.Archive = &archive
+	}
+	return summary
+}
+
+func channelsSummaryOf(chanman middleware.ChannelManager) ChannelsSummary {
+	var result ChannelsSummary
+	result.Status = chanman.Status().String()
+	if reqChan, err := chanman.ReqChan(); err == nil {
+		result.Request = ChannelSummary{len(reqChan), cap(reqChan)}
+	}
+	if resChan, err := chanman.ResChan(); err == nil {
+		result.Response = ChannelSummary{len(resChan), cap(resChan)}
+	}
+	if itemChan, err := chanman.ItemChan(); err == nil {
+		result.Item = ChannelSummary{len(itemChan), cap(itemChan)}
+	}
+	if errorChan, err := chanman.ErrorChan(); err == nil {
+		result.Error = ChannelSummary{len(errorChan), cap(errorChan)}
+	}
+	return result
+}
+
+func (s SchedSummary) String() string {
+	return s.getSummary(false)
+}
+
+func (s SchedSummary) Detail() string {
+	return s.getSummary(true)
+}
+
+// the detail contains the summary of each component besides the pool usage
+func (s SchedSummary) getSummary(detail bool) string {
+	prefix := s.Prefix
+	var buf bytes.Buffer
+	buf.WriteString(prefix + "Status: " + s.Status + "\n")
+	buf.WriteString(fmt.Sprintf(prefix+"Uptime: %s\n", s.Uptime.Round(time.Millisecond)))
+	buf.WriteString(fmt.Sprintf(prefix+"Channel length: %d\n", s.ChannelLen))
+	buf.WriteString(fmt.Sprintf(prefix+"Pool size: %d\n", s.PoolSize))
+	buf.WriteString(fmt.Sprintf(prefix+"Crawl depth: %d\n", s.CrawlDepth))
+	buf.WriteString(fmt.Sprintf(prefix+"Downloader pool: %d/%d, completed: %d\n",
+		s.Downloader.Used, s.Downloader.Total, s.Downloader.Completed))
+	buf.WriteString(fmt.Sprintf(prefix+"Analyzer pool: %d/%d, completed: %d\n",
+		s.Analyzer.Used, s.Analyzer.Total, s.Analyzer.Completed))
+	buf.WriteString(fmt.Sprintf(prefix+"Seen urls: %d, duplicates: %d\n", s.Urls.Seen, s.Urls.Duplicates))
+	buf.WriteString(fmt.Sprintf(prefix+"Retries: %d\n", s.Urls.Retries))
+	if detail {
+		channels := s.Channels
+		buf.WriteString(fmt.Sprintf(prefix+"Channel manager: status: %s, request channel: %d/%d, "+
+			"response channel: %d/%d, item channel: %d/%d, error channel: %d/%d\n",
+			channels.Status, channels.Request.Length, channels.Request.Capacity,
+			channels.Response.Length, channels.Response.Capacity,
+			channels.Item.Length, channels.Item.Capacity,
+			channels.Error.Length, channels.Error.Capacity))
+		buf.WriteString(fmt.Sprintf(prefix+"Request cache: length: %d, capacity: %d\n",
+			s.RequestCache.Length, s.RequestCache.Capacity))
+		pipeline := s.ItemPipeline
+		buf.WriteString(fmt.Sprintf(prefix+"Item pipeline: failFast: %v, sent: %d, accepted: %d, "+
+			"processed: %d, processingNumber: %d\n",
+			pipeline.FailFast, pipeline.Sent, pipeline.Accepted, pipeline.Processed, pipeline.Processing))
+		if s.SeenSet.Type != "" {
+			buf.WriteString(prefix + "Seen set: " + s.SeenSet.String() + "\n")
+		}
+		if s.Politeness != nil {
+			buf.WriteString(fmt.Sprintf(prefix+"Politeness: pending: %d, dropped: %d\n",
+				s.Politeness.Pending, s.Politeness.Dropped))
+		}
+		if s.Urls.Rejected != nil {
+			buf.WriteString(prefix + "Scope: rejected: " + formatCounts(s.Urls.Rejected) + "\n")
+		}
+		if s.Robots != nil {
+			buf.WriteString(fmt.Sprintf(prefix+"Robots: userAgent: %s, hosts: %d\n",
+				s.Robots.UserAgent, s.Robots.Hosts))
+		}
+		if s.HttpCache != nil {
+			buf.WriteString(fmt.Sprintf(prefix+"Http cache: hits: %d, revalidated: %d, misses: %d, "+
+				"stored: %d, failed: %d\n", s.HttpCache.Hits, s.HttpCache.Revalidated,
+				s.HttpCache.Misses, s.HttpCache.Stored, s.HttpCache.Failed))
+		}
+		if s.Archive != nil {
+			buf.WriteString(prefix + "Archive: " + s.Archive.String() + "\n")
+		}
+		dealCounts := make(map[string]uint64, len(s.StopSign.DealCounts))
+		for code, count := range s.StopSign.DealCounts {
+			dealCounts[code] = uint64(count)
+		}
+		buf.WriteString(fmt.Sprintf(prefix+"Stop sign: signed: %v, dealCount: %s\n",
+			s.StopSign.Signed, formatCounts(dealCounts)))
+	}
+	return buf.String()
+}
+
+// e.g. "mode: record, recorded: 12"
+func (s ArchiveSummary) String() string {
+	if s.Mode == ARCHIVE_MODE_RECORD {
+		return fmt.Sprintf("mode: %s, recorded: %d", s.Mode, s.Recorded)
+	}
+	return fmt.Sprintf("mode: %s, requests: %d, served: %d, missing: %d",
+		s.Mode, s.Requests, s.Served, s.Missing)
+}
+
+// format the counts sorted by key, e.g. {a: 1, b: 2}
+func formatCounts(counts map[string]uint64) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s: %d", key, counts[key]))
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// Same reports whether the two snapshots are equal except for the time they are taken
+func (s SchedSummary) Same(other SchedSummary) bool {
+	s.Prefix, other.Prefix = "", ""
+	s.Time, other.Time = time.Time{}, time.Time{}
+	s.Uptime, other.Uptime = 0, 0
+	return reflect.DeepEqual(s, other)
+}
+
+// SchedSummaryDiff is the progress between two snapshots
+type SchedSummaryDiff struct {
+	Elapsed        time.Duration `json:"elapsed"`
+	StatusChanged  bool          `json:"status_changed"`
+	Downloaded     int64         `json:"downloaded"`
+	Analyzed       int64         `json:"analyzed"`
+	ItemsSent      int64         `json:"items_sent"`
+	ItemsProcessed int64         `json:"items_processed"`
+	SeenUrls       int64         `json:"seen_urls"`
+	Duplicates     int64         `json:"duplicates"`
+	Retries        int64         `json:"retries"`
+	//the change of the request cache length, negative if the queue shrinks
+	Queued int64 `json:"queued"`
+	//the deal counts changed, by stop sign processor code
+	DealCounts map[string]int64 `json:"deal_counts,omitempty"`
+}
+
+// Diff computes the changes since the previous snapshot
+func (s SchedSummary) Diff(previous SchedSummary) SchedSummaryDiff {
+	diff := SchedSummaryDiff{
+		Elapsed:        s.Time.Sub(previous.Time),
+		StatusChanged:  s.Status != previous.Status,
+		Downloaded:     int64(s.Downloader.Completed) - int64(previous.Downloader.Completed),
+		Analyzed:       int64(s.Analyzer.Completed) - int64(previous.Analyzer.Completed),
+		ItemsSent:      int64(s.ItemPipeline.Sent) - int64(previous.ItemPipeline.Sent),
+		ItemsProcessed: int64(s.ItemPipeline.Processed) - int64(previous.ItemPipeline.Processed),
+		SeenUrls:       int64(s.Urls.Seen) - int64(previous.Urls.Seen),
+		Duplicates:     int64(s.Urls.Duplicates) - int64(previous.Urls.Duplicates),
+		Retries:        int64(s.Urls.Retries) - int64(previous.Urls.Retries),
+		Queued:         int64(s.RequestCache.Length) - int64(previous.RequestCache.Length),
+	}
+	for code, count := range s.StopSign.DealCounts {
+		if delta := int64(count) - int64(previous.StopSign.DealCounts[code]); delta != 0 {
+			if diff.DealCounts == nil {
+				diff.DealCounts = make(map[string]int64)
+			}
+			diff.DealCounts[code] = delta
+		}
+	}
+	return diff
+}
+
+// the rates are per second, e.g. "downloaded: 12 (2.40/s)"
+func (d SchedSummaryDiff) String() string {
+	rate := func(delta int64) string {
+		if d.Elapsed <= 0 {
+			return fmt.Sprintf("%d", delta)
+		}
+		return fmt.Sprintf("%d (%.2f/s)", delta, float64(delta)/d.Elapsed.Seconds())
+	}
+	return fmt.Sprintf("elapsed: %s, downloaded: %s, analyzed: %s, items: %s, seen urls: %s, "+
+		"duplicates: %d, retries: %d, queued: %+d",
+		d.Elapsed.Round(time.Millisecond), rate(d.Downloaded), rate(d.Analyzed),
+		rate(d.ItemsProcessed), rate(d.SeenUrls), d.Duplicates, d.Retries, d.Queued)
+}
@@ -0,0 +1,88 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedSummaryComponents(t *testing.T) {
+	server := newTestSiteServer(nil)
+	defer server.Close()
+	dir := t.TempDir()
+	sched := NewScheduler(
+		WithRobots(RobotsArgs{UserAgent: "test-agent"}),
+		WithHttpCache(HttpCacheArgs{Dir: filepath.Join(dir, "cache")}),
+		WithRecording(filepath.Join(dir, "archive.jsonl")),
+	)
+	if summary := sched.Summary(""); summary.Robots != nil || summary.Uptime != 0 {
+		t.Errorf("summary before the start = %+v, want no components", summary)
+	}
+	startTestScheduler(t, sched, context.Background(), server, 3)
+	waitIdle(t, sched, 5*time.Second)
+	sched.Stop()
+
+	summary := sched.Summary("")
+	if summary.SeenSet.Type != "memory" || summary.SeenSet.Count != 7 {
+		t.Errorf("seen set = %+v, want memory with 7 urls", summary.SeenSet)
+	}
+	if summary.Robots == nil || summary.Robots.UserAgent != "test-agent" || summary.Robots.Hosts != 1 {
+		t.Errorf("robots = %+v, want test-agent with 1 host", summary.Robots)
+	}
+	if summary.HttpCache == nil || summary.HttpCache.Misses != 7 {
+		t.Errorf("http cache = %+v, want 7 misses", summary.HttpCache)
+	}
+	//the robots.txt is recorded besides the pages
+	if summary.Archive == nil || summary.Archive.Mode != ARCHIVE_MODE_RECORD || summary.Archive.Recorded != 8 {
+		t.Errorf("archive = %+v, want 8 records", summary.Archive)
+	}
+	content, err := json.Marshal(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"robots":{"user_agent":"test-agent","hosts":1}`, `"mode":"record"`} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("json %s, want %s", content, want)
+		}
+	}
+	detail := summary.Detail()
+	for _, want := range []string{"Seen set: type: memory, count: 7", "Robots: userAgent: test-agent, hosts: 1",
+		"Archive: mode: record, recorded: 8"} {
+		if !strings.Contains(detail, want) {
+			t.Errorf("detail %q, want %q", detail, want)
+		}
+	}
+}
+
+func TestSchedSummaryDuringRestart(t *testing.T) {
+	server := newTestSiteServer(nil)
+	defer server.Close()
+	sched := NewScheduler(WithPoliteness(PolitenessArgs{}))
+	done := make(chan struct{})
+	var reader sync.WaitGroup
+	reader.Add(1)
+	go func() {
+		defer reader.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				sched.Summary("").Detail()
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		startTestScheduler(t, sched, context.Background(), server, 10)
+		sched.Stop()
+	}
+	close(done)
+	reader.Wait()
+	if summary := sched.Summary(""); summary.Politeness == nil || summary.PoolSize != 3 {
+		t.Errorf("summary after restarts = %+v, want the components of the last run", summary)
+	}
+}